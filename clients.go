@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"music-auth/global/db"
+	"music-auth/internal/idp"
+	"strings"
+)
+
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runClients implements `music-auth clients create|list`, which registers
+// the apps allowed to log users in through the OIDC provider.
+func runClients(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: clients create [-tenant t] -name n -redirect-uri u [-public] [-first-party] [-scopes s] | clients list")
+	}
+
+	conn, err := db.Open()
+	if err != nil {
+		return fmt.Errorf("connect DB: %w", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		var redirects listFlag
+		flags := flag.NewFlagSet("clients create", flag.ContinueOnError)
+		tenant := flags.String("tenant", defaultTenant(), "tenant whose users the client may log in")
+		name := flags.String("name", "", "display name shown on the consent page")
+		flags.Var(&redirects, "redirect-uri", "allowed redirect URI (repeatable)")
+		public := flags.Bool("public", false, "no client secret; PKCE is required")
+		firstParty := flags.Bool("first-party", false, "skip the consent page")
+		scopes := flags.String("scopes", "", "space separated scopes the client may request")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		clientID, secret, err := idp.CreateClient(ctx, conn, idp.ClientSpec{
+			Tenant:       *tenant,
+			Name:         *name,
+			RedirectURIs: redirects,
+			Scopes:       strings.Fields(*scopes),
+			Public:       *public,
+			FirstParty:   *firstParty,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("client_id:     %s\n", clientID)
+		if secret != "" {
+			fmt.Printf("client_secret: %s\n", secret)
+			fmt.Println("store the secret now, it cannot be shown again")
+		}
+
+	case "list":
+		clients, err := idp.ListClients(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, c := range clients {
+			kind := "confidential"
+			if c.Public() {
+				kind = "public"
+			}
+			fmt.Printf("%-22s  %-16s  %-12s  %-20s  %s\n", c.ID, c.TenantID, kind, c.Name, strings.Join(c.RedirectURIs, " "))
+		}
+
+	default:
+		return fmt.Errorf("unknown clients command %q", args[0])
+	}
+
+	return nil
+}
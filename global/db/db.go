@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -9,8 +10,31 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// ConnectDB opens the database and, unless DB_AUTO_MIGRATE=false, brings the
+// schema up to date before returning.
 func ConnectDB() (*sql.DB, error) {
 
+	db, err := Open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("DB_AUTO_MIGRATE") != "false" {
+		applied, err := MigrateUp(context.Background(), db)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+		slog.Info("Schema up to date", "applied", applied)
+	}
+
+	return db, nil
+
+}
+
+func Open() (*sql.DB, error) {
+
 	host := os.Getenv("DB_HOST")
 	port := os.Getenv("DB_PORT")
 	user := os.Getenv("DB_USER")
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS users (
+    id                       UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    username                 TEXT NOT NULL,
+    email                    TEXT NOT NULL,
+    password                 TEXT NOT NULL,
+    subscription_type        TEXT NOT NULL DEFAULT 'free',
+    ending_subscription_date DATE,
+    created_at               TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at               TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT users_email_key UNIQUE (email),
+    CONSTRAINT users_username_key UNIQUE (username)
+);
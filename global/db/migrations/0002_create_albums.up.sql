@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS albums (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    title         TEXT NOT NULL,
+    artist        TEXT,
+    release_date  DATE,
+    cover_art_key TEXT,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS albums_user_id_idx ON albums (user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS tracks (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    album_id   UUID REFERENCES albums (id) ON DELETE SET NULL,
+    title      TEXT NOT NULL,
+    artist     TEXT,
+    genre      TEXT,
+    duration   INTEGER,
+    file_size  BIGINT,
+    format     TEXT NOT NULL,
+    key        TEXT NOT NULL,
+    cdn_url    TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT tracks_key_key UNIQUE (key)
+);
+
+CREATE INDEX IF NOT EXISTS tracks_user_id_idx ON tracks (user_id);
+CREATE INDEX IF NOT EXISTS tracks_album_id_idx ON tracks (album_id);
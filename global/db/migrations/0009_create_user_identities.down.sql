@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_identities;
+
+-- Fails if passwordless accounts exist; delete or reset them first.
+ALTER TABLE users ALTER COLUMN password SET NOT NULL;
@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    client_id          TEXT PRIMARY KEY,
+    client_secret_hash TEXT,
+    name               TEXT NOT NULL,
+    redirect_uris      TEXT[] NOT NULL,
+    allowed_scopes     TEXT[] NOT NULL DEFAULT ARRAY['openid', 'profile', 'email', 'offline_access'],
+    first_party        BOOLEAN NOT NULL DEFAULT false,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id    UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id  TEXT NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+    scopes     TEXT[] NOT NULL,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    code_hash             TEXT PRIMARY KEY,
+    client_id             TEXT NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+    user_id               UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri          TEXT NOT NULL,
+    scopes                TEXT[] NOT NULL,
+    nonce                 TEXT,
+    code_challenge        TEXT,
+    code_challenge_method TEXT,
+    auth_time             TIMESTAMPTZ NOT NULL,
+    expires_at            TIMESTAMPTZ NOT NULL,
+    used_at               TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS oauth_refresh_tokens (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash TEXT NOT NULL,
+    client_id  TEXT NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+    user_id    UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    scopes     TEXT[] NOT NULL,
+    auth_time  TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT oauth_refresh_tokens_token_hash_key UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_user_client_idx ON oauth_refresh_tokens (user_id, client_id);
@@ -0,0 +1,21 @@
+-- Fails if two tenants share an email, username or provider account.
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE user_identities DROP CONSTRAINT user_identities_provider_subject_key;
+ALTER TABLE user_identities ADD CONSTRAINT user_identities_provider_subject_key UNIQUE (provider, subject);
+ALTER TABLE user_identities DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS tracks_tenant_user_idx;
+ALTER TABLE tracks DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS albums_tenant_user_idx;
+ALTER TABLE albums DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE users DROP CONSTRAINT users_tenant_id_id_key;
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS tenants (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    hosts      TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Every existing row belonged to the single hard-coded tenant.
+INSERT INTO tenants (id, name) VALUES ('music-store', 'Music Store') ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'music-store' REFERENCES tenants (id);
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Email and username are unique per tenant. The constraint names are kept
+-- because the service maps them to user-facing errors.
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (tenant_id, email);
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (tenant_id, username);
+
+-- Lets the tables below reference (tenant_id, user_id), so a row can never
+-- point at a user of another tenant.
+ALTER TABLE users ADD CONSTRAINT users_tenant_id_id_key UNIQUE (tenant_id, id);
+
+ALTER TABLE albums ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'music-store';
+ALTER TABLE albums ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE albums ADD CONSTRAINT albums_tenant_user_fkey
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS albums_tenant_user_idx ON albums (tenant_id, user_id);
+
+ALTER TABLE tracks ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'music-store';
+ALTER TABLE tracks ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE tracks ADD CONSTRAINT tracks_tenant_user_fkey
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS tracks_tenant_user_idx ON tracks (tenant_id, user_id);
+
+-- The same provider account may sign in to several storefronts, each with
+-- its own user.
+ALTER TABLE user_identities ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'music-store';
+ALTER TABLE user_identities ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE user_identities ADD CONSTRAINT user_identities_tenant_user_fkey
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE;
+ALTER TABLE user_identities DROP CONSTRAINT user_identities_provider_subject_key;
+ALTER TABLE user_identities ADD CONSTRAINT user_identities_provider_subject_key UNIQUE (tenant_id, provider, subject);
+
+ALTER TABLE oauth_clients ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'music-store' REFERENCES tenants (id) ON DELETE CASCADE;
+ALTER TABLE oauth_clients ALTER COLUMN tenant_id DROP DEFAULT;
@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role       TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('listener', 'Streams music and manages their own account'),
+    ('artist', 'Uploads and manages their own tracks and albums'),
+    ('label_admin', 'Manages the catalog of every artist in the tenant'),
+    ('platform_admin', 'Manages users and roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('tracks:upload', 'Upload tracks'),
+    ('albums:manage', 'Create and edit own albums'),
+    ('catalog:manage', 'Edit and delete any track or album in the tenant'),
+    ('users:read', 'Look up users'),
+    ('users:manage', 'Suspend, reinstate, log out and delete users'),
+    ('users:impersonate', 'Act as another user'),
+    ('roles:assign', 'Change the role of a user')
+ON CONFLICT (name) DO NOTHING;
+
+-- Each role holds the permissions of the roles below it as well.
+INSERT INTO role_permissions (role, permission) VALUES
+    ('artist', 'tracks:upload'),
+    ('artist', 'albums:manage'),
+    ('label_admin', 'tracks:upload'),
+    ('label_admin', 'albums:manage'),
+    ('label_admin', 'catalog:manage'),
+    ('label_admin', 'users:read'),
+    ('platform_admin', 'tracks:upload'),
+    ('platform_admin', 'albums:manage'),
+    ('platform_admin', 'catalog:manage'),
+    ('platform_admin', 'users:read'),
+    ('platform_admin', 'users:manage'),
+    ('platform_admin', 'users:impersonate'),
+    ('platform_admin', 'roles:assign')
+ON CONFLICT DO NOTHING;
+
+-- Uploading used to be open to everyone, so existing users who already
+-- uploaded keep that ability.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'listener' REFERENCES roles (name);
+UPDATE users SET role = 'artist' WHERE id IN (SELECT DISTINCT user_id FROM tracks);
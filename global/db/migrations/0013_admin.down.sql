@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS users_tenant_created_idx;
+DROP TABLE IF EXISTS admin_audit_log;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS impersonator_id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
@@ -0,0 +1,24 @@
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN suspended_reason TEXT;
+-- Set when an account is deleted but its tracks are kept: the row stays as
+-- an anonymous owner with every personal field scrubbed.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Sessions opened by an admin acting as the user.
+ALTER TABLE sessions ADD COLUMN impersonator_id UUID REFERENCES users (id) ON DELETE CASCADE;
+
+-- Entries outlive the users they mention, so target_user_id has no foreign key.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id      TEXT NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+    actor_id       UUID REFERENCES users (id) ON DELETE SET NULL,
+    action         TEXT NOT NULL,
+    target_user_id UUID,
+    details        JSONB NOT NULL DEFAULT '{}',
+    ip_address     TEXT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_tenant_created_idx ON admin_audit_log (tenant_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_idx ON admin_audit_log (target_user_id);
+CREATE INDEX IF NOT EXISTS users_tenant_created_idx ON users (tenant_id, created_at DESC, id DESC);
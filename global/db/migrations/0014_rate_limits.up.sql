@@ -0,0 +1,10 @@
+-- Counters for login, sign-up and password reset throttling. Keys are
+-- namespaced by flow, e.g. "login:account:<tenant>:<email>" or "login:ip:<ip>".
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key         TEXT PRIMARY KEY,
+    hits        INTEGER NOT NULL,
+    last_hit_at TIMESTAMPTZ NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
@@ -0,0 +1,2 @@
+ALTER TABLE tracks DROP CONSTRAINT IF EXISTS tracks_album_position_key;
+ALTER TABLE tracks DROP COLUMN IF EXISTS album_position;
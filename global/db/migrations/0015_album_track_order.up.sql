@@ -0,0 +1,15 @@
+-- Place of a track in its album, 1-based. Deferred so a reorder can swap
+-- positions within one statement.
+ALTER TABLE tracks ADD COLUMN album_position INTEGER;
+
+UPDATE tracks t
+SET album_position = o.position
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY album_id ORDER BY created_at, id) AS position
+    FROM tracks
+    WHERE album_id IS NOT NULL
+) o
+WHERE t.id = o.id;
+
+ALTER TABLE tracks ADD CONSTRAINT tracks_album_position_key
+    UNIQUE (album_id, album_position) DEFERRABLE INITIALLY DEFERRED;
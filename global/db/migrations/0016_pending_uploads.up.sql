@@ -0,0 +1,18 @@
+-- Keys handed out by getPresignedURLForUploadingTrack that have not been
+-- saved as a track yet. saveTrack only accepts keys listed here for the
+-- same user, and removes them.
+CREATE TABLE IF NOT EXISTS pending_uploads (
+    key          TEXT PRIMARY KEY,
+    tenant_id    TEXT NOT NULL,
+    user_id      UUID NOT NULL,
+    content_type TEXT NOT NULL,
+    -- Size the client declared, signed into the upload URL. NULL if none.
+    file_size    BIGINT,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT pending_uploads_tenant_user_fkey
+        FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS pending_uploads_user_id_idx ON pending_uploads (user_id);
+CREATE INDEX IF NOT EXISTS pending_uploads_expires_at_idx ON pending_uploads (expires_at);
@@ -0,0 +1,5 @@
+ALTER TABLE tracks
+    DROP COLUMN IF EXISTS tags,
+    DROP COLUMN IF EXISTS bitrate,
+    DROP COLUMN IF EXISTS channels,
+    DROP COLUMN IF EXISTS sample_rate;
@@ -0,0 +1,7 @@
+-- Technical metadata read from the uploaded file. Tags holds the embedded
+-- title, artist, album, genre, date and track number by those names.
+ALTER TABLE tracks
+    ADD COLUMN sample_rate INTEGER,
+    ADD COLUMN channels    SMALLINT,
+    ADD COLUMN bitrate     INTEGER,
+    ADD COLUMN tags        JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS pending_uploads_upload_id_key;
+
+ALTER TABLE pending_uploads
+    DROP COLUMN IF EXISTS completed_at,
+    DROP COLUMN IF EXISTS upload_id;
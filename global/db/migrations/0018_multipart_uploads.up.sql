@@ -0,0 +1,7 @@
+-- Multipart uploads are pending uploads with an S3 upload id. They can be
+-- saved once completed_at is set; incomplete ones are aborted once expired.
+ALTER TABLE pending_uploads
+    ADD COLUMN upload_id    TEXT,
+    ADD COLUMN completed_at TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX IF NOT EXISTS pending_uploads_upload_id_key ON pending_uploads (upload_id);
@@ -0,0 +1,25 @@
+-- Transcoded versions of a track. Rows are created pending when the track is
+-- saved and double as the transcoder's work queue.
+CREATE TABLE IF NOT EXISTS track_renditions (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    track_id    UUID NOT NULL REFERENCES tracks (id) ON DELETE CASCADE,
+    preset      TEXT NOT NULL,
+    codec       TEXT NOT NULL,
+    -- kbit/s
+    bitrate     INTEGER NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'pending'
+                CHECK (status IN ('pending', 'processing', 'ready', 'failed')),
+    key         TEXT,
+    file_size   BIGINT,
+    attempts    INTEGER NOT NULL DEFAULT 0,
+    error       TEXT,
+    run_after   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at  TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (track_id, preset)
+);
+
+CREATE INDEX IF NOT EXISTS track_renditions_queue_idx
+    ON track_renditions (run_after)
+    WHERE status IN ('pending', 'processing');
@@ -0,0 +1 @@
+ALTER TABLE track_renditions DROP COLUMN IF EXISTS hls;
@@ -0,0 +1,3 @@
+-- HLS variants are renditions too; their key is the media playlist, next to
+-- its segments.
+ALTER TABLE track_renditions ADD COLUMN hls BOOLEAN NOT NULL DEFAULT false;
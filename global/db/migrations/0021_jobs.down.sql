@@ -0,0 +1,10 @@
+ALTER TABLE track_renditions
+    ADD COLUMN IF NOT EXISTS attempts   INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS run_after  TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS track_renditions_queue_idx
+    ON track_renditions (run_after)
+    WHERE status IN ('pending', 'processing');
+
+DROP TABLE IF EXISTS jobs;
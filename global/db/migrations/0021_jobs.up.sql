@@ -0,0 +1,37 @@
+-- Background jobs. Finished jobs are deleted; dead ones, out of attempts,
+-- stay for inspection and are pruned after 30 days.
+CREATE TABLE IF NOT EXISTS jobs (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind         TEXT NOT NULL,
+    payload      JSONB NOT NULL DEFAULT 'null',
+    status       TEXT NOT NULL DEFAULT 'queued'
+                 CHECK (status IN ('queued', 'running', 'dead')),
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- A running job whose lock ran out is taken over by another worker.
+    locked_until TIMESTAMPTZ,
+    -- At most one queued or running job per key.
+    unique_key   TEXT,
+    last_error   TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS jobs_queue_idx ON jobs (run_at) WHERE status IN ('queued', 'running');
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS jobs_dead_idx ON jobs (updated_at) WHERE status = 'dead';
+
+-- Transcoding now runs as jobs; renditions only record their state.
+DROP INDEX IF EXISTS track_renditions_queue_idx;
+
+ALTER TABLE track_renditions
+    DROP COLUMN IF EXISTS run_after,
+    DROP COLUMN IF EXISTS started_at,
+    DROP COLUMN IF EXISTS attempts;
+
+-- Renditions that were still queued carry on as jobs.
+INSERT INTO jobs (kind, payload, max_attempts, unique_key)
+SELECT DISTINCT 'track.transcode', json_build_object('track_id', track_id)::jsonb, 3, 'transcode:' || track_id
+FROM track_renditions
+WHERE status IN ('pending', 'processing');
@@ -0,0 +1,3 @@
+-- Tracks saved from an S3 event before the client called saveTrack, with a
+-- title from the file. saveTrack can still fill in their details once.
+ALTER TABLE tracks ADD COLUMN IF NOT EXISTS auto_saved BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,107 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.81
+
+import (
+	"context"
+	"log/slog"
+	"music-auth/graph/model"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SuspendUser is the resolver for the suspendUser field.
+func (r *mutationResolver) SuspendUser(ctx context.Context, userID uuid.UUID, reason *string) (*model.BasicResponse, error) {
+	if err := r.AuthService.SuspendUser(ctx, userID, reason); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "User suspended",
+	}, nil
+}
+
+// ReinstateUser is the resolver for the reinstateUser field.
+func (r *mutationResolver) ReinstateUser(ctx context.Context, userID uuid.UUID) (*model.BasicResponse, error) {
+	if err := r.AuthService.ReinstateUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "User reinstated",
+	}, nil
+}
+
+// ForceLogout is the resolver for the forceLogout field.
+func (r *mutationResolver) ForceLogout(ctx context.Context, userID uuid.UUID) (*model.BasicResponse, error) {
+	if err := r.AuthService.ForceLogout(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "User logged out of all devices",
+	}, nil
+}
+
+// SetRole is the resolver for the setRole field.
+func (r *mutationResolver) SetRole(ctx context.Context, userID uuid.UUID, role model.Role) (*model.BasicResponse, error) {
+	if err := r.AuthService.SetRole(ctx, userID, strings.ToLower(string(role))); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Role updated",
+	}, nil
+}
+
+// ImpersonateUser is the resolver for the impersonateUser field.
+func (r *mutationResolver) ImpersonateUser(ctx context.Context, userID uuid.UUID, reason string) (*model.BasicResponse, error) {
+	tokens, err := r.AuthService.ImpersonateUser(ctx, userID, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Impersonating user, log out to stop",
+	}, nil
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, userID uuid.UUID, tracks model.DeletedUserTracks) (*model.BasicResponse, error) {
+	keys, err := r.AuthService.DeleteUser(ctx, userID, tracks == model.DeletedUserTracksAnonymize)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account is gone either way; orphaned files are only wasted space.
+	if err := r.MusicService.QueueFileDeletion(ctx, nil, keys); err != nil {
+		slog.Error("delete tracks of deleted user", "user_id", userID, "error", err)
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "User deleted",
+	}, nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, filter *model.UserFilter, first *int32, after *string) (*model.UserConnection, error) {
+	return r.AuthService.SearchUsers(ctx, filter, pageSize(first), after)
+}
+
+// AuditLog is the resolver for the auditLog field.
+func (r *queryResolver) AuditLog(ctx context.Context, userID *uuid.UUID, first *int32) ([]*model.AuditEntry, error) {
+	return r.AuthService.AuditLog(ctx, userID, pageSize(first))
+}
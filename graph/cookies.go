@@ -0,0 +1,72 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"music-auth/internal/auth"
+	"music-auth/internal/middleware"
+	"net/http"
+)
+
+func setSessionCookies(ctx context.Context, tokens *auth.TokenPair) error {
+	rw := middleware.GetResponseWriter(ctx)
+	if rw == nil {
+		return fmt.Errorf("could not get response writer")
+	}
+
+	middleware.SetSessionCookies(rw, tokens.AccessToken, tokens.AccessExpiresAt, tokens.RefreshToken, tokens.RefreshExpiresAt)
+	return nil
+}
+
+func clearSessionCookies(ctx context.Context) error {
+	rw := middleware.GetResponseWriter(ctx)
+	if rw == nil {
+		return fmt.Errorf("could not get response writer")
+	}
+
+	middleware.ClearSessionCookies(rw)
+	return nil
+}
+
+// setCDNCookies hands CloudFront signed cookies to the browser.
+func setCDNCookies(ctx context.Context, cookies []*http.Cookie) error {
+	rw := middleware.GetResponseWriter(ctx)
+	if rw == nil {
+		return fmt.Errorf("could not get response writer")
+	}
+
+	for _, c := range cookies {
+		http.SetCookie(rw, c)
+	}
+	return nil
+}
+
+func readRefreshCookie(ctx context.Context) string {
+	return readCookie(ctx, middleware.RefreshCookieName)
+}
+
+// readMFACookie returns the challenge token a social login left for
+// verifyMFA.
+func readMFACookie(ctx context.Context) string {
+	return readCookie(ctx, middleware.MFACookieName)
+}
+
+func clearMFACookie(ctx context.Context) {
+	if rw := middleware.GetResponseWriter(ctx); rw != nil {
+		middleware.ClearMFACookie(rw)
+	}
+}
+
+func readCookie(ctx context.Context, name string) string {
+	r := middleware.GetRequest(ctx)
+	if r == nil {
+		return ""
+	}
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
@@ -0,0 +1,166 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// CostLimit rejects operations that are too expensive or too deeply nested
+// before any resolver runs. A field costs the weight from its @cost
+// annotation (1 for fields with selections, 0 for scalars) plus the cost of
+// its selections, multiplied by each argument named in multipliers, e.g. the
+// page size of a list. Introspection is not counted.
+type CostLimit struct {
+	MaxCost  int
+	MaxDepth int
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = CostLimit{}
+
+func (CostLimit) ExtensionName() string {
+	return "CostLimit"
+}
+
+// Validate checks that every multiplier names an argument of its field, so a
+// typo in the schema fails at startup instead of silently costing nothing.
+func (CostLimit) Validate(schema graphql.ExecutableSchema) error {
+	for _, def := range schema.Schema().Types {
+		for _, field := range def.Fields {
+			for _, name := range costMultipliers(field) {
+				if field.Arguments.ForName(name) == nil {
+					return fmt.Errorf("@cost on %s.%s: no argument %q", def.Name, field.Name, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c CostLimit) MutateOperationContext(ctx context.Context, opCtx *graphql.OperationContext) *gqlerror.Error {
+	cost, depth := selectionCost(opCtx.Operation.SelectionSet, opCtx.Variables, 1)
+
+	if c.MaxDepth > 0 && depth > c.MaxDepth {
+		err := gqlerror.Errorf("operation is nested %d levels deep, the limit is %d", depth, c.MaxDepth)
+		err.Extensions = map[string]any{"code": "QUERY_TOO_DEEP", "depth": depth, "limit": c.MaxDepth}
+		return err
+	}
+
+	if c.MaxCost > 0 && cost > c.MaxCost {
+		err := gqlerror.Errorf("operation costs %d, the limit is %d", cost, c.MaxCost)
+		err.Extensions = map[string]any{"code": "QUERY_TOO_COMPLEX", "cost": cost, "limit": c.MaxCost}
+		return err
+	}
+
+	return nil
+}
+
+// selectionCost returns the cost of a selection set and how deep it goes,
+// counting level as the depth of its own fields.
+func selectionCost(set ast.SelectionSet, vars map[string]any, level int) (cost, depth int) {
+	for _, sel := range set {
+		var c, d int
+
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name, "__") {
+				continue
+			}
+			c, d = fieldCost(sel, vars, level)
+		case *ast.InlineFragment:
+			c, d = selectionCost(sel.SelectionSet, vars, level)
+		case *ast.FragmentSpread:
+			if sel.Definition != nil {
+				c, d = selectionCost(sel.Definition.SelectionSet, vars, level)
+			}
+		}
+
+		cost += c
+		depth = max(depth, d)
+	}
+	return cost, depth
+}
+
+func fieldCost(field *ast.Field, vars map[string]any, level int) (int, int) {
+	weight := 0
+	if len(field.SelectionSet) > 0 {
+		weight = 1
+	}
+
+	if field.Definition == nil {
+		return weight, level
+	}
+
+	if d := field.Definition.Directives.ForName("cost"); d != nil {
+		if arg := d.Arguments.ForName("weight"); arg != nil {
+			if v, ok := costInt(arg.Value.Raw); ok {
+				weight = v
+			}
+		}
+	}
+
+	children, depth := selectionCost(field.SelectionSet, vars, level+1)
+	depth = max(depth, level)
+
+	args := field.ArgumentMap(vars)
+	for _, name := range costMultipliers(field.Definition) {
+		n, ok := costInt(args[name])
+		if !ok {
+			if def := field.Definition.Arguments.ForName(name); def != nil && def.DefaultValue != nil {
+				n, _ = costInt(def.DefaultValue.Raw)
+			}
+		}
+		children *= max(n, 1)
+	}
+
+	return weight + children, depth
+}
+
+func costMultipliers(field *ast.FieldDefinition) []string {
+	d := field.Directives.ForName("cost")
+	if d == nil {
+		return nil
+	}
+
+	arg := d.Arguments.ForName("multipliers")
+	if arg == nil || arg.Value == nil {
+		return nil
+	}
+
+	var names []string
+	for _, child := range arg.Value.Children {
+		names = append(names, child.Value.Raw)
+	}
+	return names
+}
+
+// costInt reads an Int argument, which arrives as a literal, a decoded JSON
+// variable or a raw schema value.
+func costInt(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	case string:
+		var n int
+		_, err := fmt.Sscan(v, &n)
+		return n, err == nil
+	}
+	return 0, false
+}
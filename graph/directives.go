@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"music-auth/graph/model"
+	"music-auth/internal/middleware"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// Directives enforces the access rules declared in the schema, so resolvers
+// and services behind them can rely on middleware.CurrentUser.
+func Directives() DirectiveRoot {
+	return DirectiveRoot{
+		Auth: func(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+			if _, ok := middleware.GetUserFromContext(ctx); !ok {
+				return nil, fmt.Errorf("Unauthorized")
+			}
+			return next(ctx)
+		},
+
+		HasRole: func(ctx context.Context, obj any, next graphql.Resolver, role model.Role) (any, error) {
+			claims, ok := middleware.GetUserFromContext(ctx)
+			if !ok {
+				return nil, fmt.Errorf("Unauthorized")
+			}
+			if !claims.HasRole(strings.ToLower(string(role))) {
+				return nil, fmt.Errorf("Forbidden")
+			}
+			return next(ctx)
+		},
+
+		HasPermission: func(ctx context.Context, obj any, next graphql.Resolver, permission string) (any, error) {
+			claims, ok := middleware.GetUserFromContext(ctx)
+			if !ok {
+				return nil, fmt.Errorf("Unauthorized")
+			}
+			if !claims.HasPermission(permission) {
+				return nil, fmt.Errorf("Forbidden")
+			}
+			return next(ctx)
+		},
+	}
+}
@@ -6,29 +6,96 @@ package graph
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"music-auth/graph/model"
+	music "music-auth/music/service"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Tracks is the resolver for the tracks field.
+func (r *albumResolver) Tracks(ctx context.Context, obj *model.Album) ([]*model.Track, error) {
+	return r.MusicService.AlbumTracks(ctx, obj.ID)
+}
+
 // GetPresignedURLForUploadingTrack is the resolver for the getPresignedURLForUploadingTrack field.
-func (r *mutationResolver) GetPresignedURLForUploadingTrack(ctx context.Context, name string, contentType string) (*model.PresignedURL, error) {
-	url, key, err := r.MusicService.GetPresignedURLForTrackUploading(ctx, name, contentType)
+func (r *mutationResolver) GetPresignedURLForUploadingTrack(ctx context.Context, name string, contentType string, fileSize *int64) (*model.PresignedURL, error) {
+	upload, err := r.MusicService.GetPresignedURLForTrackUploading(ctx, name, contentType, fileSize)
 
 	if err != nil {
 		return nil, fmt.Errorf("%s", err.Error())
 	}
 
 	return &model.PresignedURL{
-		URL: url,
-		Key: key,
+		URL:       upload.URL,
+		Key:       upload.Key,
+		ExpiresAt: upload.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// InitiateMultipartUpload is the resolver for the initiateMultipartUpload field.
+func (r *mutationResolver) InitiateMultipartUpload(ctx context.Context, name string, contentType string) (*model.MultipartUpload, error) {
+	upload, err := r.MusicService.InitiateMultipartUpload(ctx, name, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.MultipartUpload{
+		UploadID:  upload.UploadID,
+		Key:       upload.Key,
+		PartSize:  int32(upload.PartSize),
+		ExpiresAt: upload.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// PresignUploadParts is the resolver for the presignUploadParts field.
+func (r *mutationResolver) PresignUploadParts(ctx context.Context, uploadID string, partNumbers []int32) ([]*model.UploadPart, error) {
+	parts, err := r.MusicService.PresignUploadParts(ctx, uploadID, partNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*model.UploadPart, len(parts))
+	for i, p := range parts {
+		res[i] = &model.UploadPart{
+			PartNumber: p.PartNumber,
+			URL:        p.URL,
+			ExpiresAt:  p.ExpiresAt.Format(time.RFC3339),
+		}
+	}
+
+	return res, nil
+}
+
+// CompleteMultipartUpload is the resolver for the completeMultipartUpload field.
+func (r *mutationResolver) CompleteMultipartUpload(ctx context.Context, uploadID string) (*model.BasicResponse, error) {
+	if err := r.MusicService.CompleteMultipartUpload(ctx, uploadID); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Upload completed",
+	}, nil
+}
+
+// AbortMultipartUpload is the resolver for the abortMultipartUpload field.
+func (r *mutationResolver) AbortMultipartUpload(ctx context.Context, uploadID string) (*model.BasicResponse, error) {
+	if err := r.MusicService.AbortMultipartUpload(ctx, uploadID); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Upload aborted",
 	}, nil
 }
 
 // SaveTrack is the resolver for the saveTrack field.
-func (r *mutationResolver) SaveTrack(ctx context.Context, albumID *uuid.UUID, title string, artist *string, genre *string, duration *int32, fileSize *int32, format string, key string) (*model.BasicResponse, error) {
-	err := r.MusicService.SaveTrackInDB(ctx, albumID, title, *artist, *genre, format, key, *duration, *fileSize)
+func (r *mutationResolver) SaveTrack(ctx context.Context, albumID *uuid.UUID, title string, artist *string, genre *string, duration *int32, fileSize *int64, format *string, key string) (*model.BasicResponse, error) {
+	err := r.MusicService.SaveTrackInDB(ctx, albumID, title, valueOf(artist), valueOf(genre), key, valueOf(fileSize))
 
 	if err != nil {
 		return nil, fmt.Errorf("%s", err.Error())
@@ -41,3 +108,137 @@ func (r *mutationResolver) SaveTrack(ctx context.Context, albumID *uuid.UUID, ti
 
 	return res, nil
 }
+
+// CreateAlbum is the resolver for the createAlbum field.
+func (r *mutationResolver) CreateAlbum(ctx context.Context, input model.CreateAlbumInput) (*model.Album, error) {
+	return r.MusicService.CreateAlbum(ctx, input)
+}
+
+// UpdateAlbum is the resolver for the updateAlbum field.
+func (r *mutationResolver) UpdateAlbum(ctx context.Context, id uuid.UUID, input model.UpdateAlbumInput) (*model.Album, error) {
+	return r.MusicService.UpdateAlbum(ctx, id, input)
+}
+
+// DeleteAlbum is the resolver for the deleteAlbum field.
+func (r *mutationResolver) DeleteAlbum(ctx context.Context, id uuid.UUID) (*model.BasicResponse, error) {
+	if err := r.MusicService.DeleteAlbum(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Album deleted",
+	}, nil
+}
+
+// ReorderAlbumTracks is the resolver for the reorderAlbumTracks field.
+func (r *mutationResolver) ReorderAlbumTracks(ctx context.Context, albumID uuid.UUID, trackIds []uuid.UUID) (*model.Album, error) {
+	return r.MusicService.ReorderAlbumTracks(ctx, albumID, trackIds)
+}
+
+// UpdateTrack is the resolver for the updateTrack field.
+func (r *mutationResolver) UpdateTrack(ctx context.Context, id uuid.UUID, input model.UpdateTrackInput) (*model.Track, error) {
+	return r.MusicService.UpdateTrack(ctx, id, input)
+}
+
+// DeleteTrack is the resolver for the deleteTrack field.
+func (r *mutationResolver) DeleteTrack(ctx context.Context, id uuid.UUID) (*model.BasicResponse, error) {
+	if err := r.MusicService.DeleteTrack(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Track deleted",
+	}, nil
+}
+
+// Album is the resolver for the album field.
+func (r *queryResolver) Album(ctx context.Context, id uuid.UUID) (*model.Album, error) {
+	album, err := r.MusicService.Album(ctx, id)
+	if errors.Is(err, music.ErrAlbumNotFound) {
+		return nil, nil
+	}
+	return album, err
+}
+
+// MyAlbums is the resolver for the myAlbums field.
+func (r *queryResolver) MyAlbums(ctx context.Context) ([]*model.Album, error) {
+	return r.MusicService.MyAlbums(ctx)
+}
+
+// Track is the resolver for the track field.
+func (r *queryResolver) Track(ctx context.Context, id uuid.UUID) (*model.Track, error) {
+	track, err := r.MusicService.Track(ctx, id)
+	if errors.Is(err, music.ErrTrackNotFound) {
+		return nil, nil
+	}
+	return track, err
+}
+
+// PlaybackURL is the resolver for the playbackURL field.
+func (r *queryResolver) PlaybackURL(ctx context.Context, trackID uuid.UUID, mode *model.PlaybackMode, rendition *string) (*model.Playback, error) {
+	playback, err := r.MusicService.PlaybackURL(ctx, trackID, valueOf(rendition), mode != nil && *mode == model.PlaybackModeCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(playback.Cookies) > 0 {
+		if err := setCDNCookies(ctx, playback.Cookies); err != nil {
+			return nil, err
+		}
+	}
+
+	return &model.Playback{
+		URL:       playback.URL,
+		ExpiresAt: playback.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// StreamManifest is the resolver for the streamManifest field.
+func (r *queryResolver) StreamManifest(ctx context.Context, trackID uuid.UUID, mode *model.PlaybackMode) (*model.Playback, error) {
+	playback, err := r.MusicService.StreamManifest(ctx, trackID, mode != nil && *mode == model.PlaybackModeCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(playback.Cookies) > 0 {
+		if err := setCDNCookies(ctx, playback.Cookies); err != nil {
+			return nil, err
+		}
+	}
+
+	return &model.Playback{
+		URL:       playback.URL,
+		ExpiresAt: playback.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// MyTracks is the resolver for the myTracks field.
+func (r *queryResolver) MyTracks(ctx context.Context, filter *model.TrackFilter, first *int32, after *string) (*model.TrackConnection, error) {
+	return r.MusicService.MyTracks(ctx, filter, pageSize(first), after)
+}
+
+// Renditions is the resolver for the renditions field.
+func (r *trackResolver) Renditions(ctx context.Context, obj *model.Track) ([]*model.Rendition, error) {
+	return r.MusicService.TrackRenditions(ctx, obj.ID)
+}
+
+// Rendition is the resolver for the rendition field.
+func (r *trackResolver) Rendition(ctx context.Context, obj *model.Track, codecs []model.AudioCodec, maxBitrate *int32) (*model.Rendition, error) {
+	renditions, err := r.MusicService.TrackRenditions(ctx, obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return music.SelectRendition(renditions, codecs, valueOf(maxBitrate)), nil
+}
+
+// Album returns AlbumResolver implementation.
+func (r *Resolver) Album() AlbumResolver { return &albumResolver{r} }
+
+// Track returns TrackResolver implementation.
+func (r *Resolver) Track() TrackResolver { return &trackResolver{r} }
+
+type albumResolver struct{ *Resolver }
+type trackResolver struct{ *Resolver }
@@ -2,6 +2,51 @@
 
 package model
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+type AdminUser struct {
+	ID              uuid.UUID `json:"id"`
+	Username        string    `json:"username"`
+	Email           string    `json:"email"`
+	EmailVerified   bool      `json:"emailVerified"`
+	Role            Role      `json:"role"`
+	Suspended       bool      `json:"suspended"`
+	SuspendedAt     *string   `json:"suspendedAt,omitempty"`
+	SuspendedReason *string   `json:"suspendedReason,omitempty"`
+	Deleted         bool      `json:"deleted"`
+	TrackCount      int32     `json:"trackCount"`
+	CreatedAt       string    `json:"createdAt"`
+}
+
+type Album struct {
+	ID          uuid.UUID `json:"id"`
+	Title       string    `json:"title"`
+	Artist      *string   `json:"artist,omitempty"`
+	ReleaseDate *string   `json:"releaseDate,omitempty"`
+	CoverArtKey *string   `json:"coverArtKey,omitempty"`
+	TrackCount  int32     `json:"trackCount"`
+	Tracks      []*Track  `json:"tracks"`
+	CreatedAt   string    `json:"createdAt"`
+	UpdatedAt   string    `json:"updatedAt"`
+}
+
+type AuditEntry struct {
+	ID           uuid.UUID  `json:"id"`
+	ActorID      *uuid.UUID `json:"actorId,omitempty"`
+	Action       string     `json:"action"`
+	TargetUserID *uuid.UUID `json:"targetUserId,omitempty"`
+	Details      string     `json:"details"`
+	IPAddress    *string    `json:"ipAddress,omitempty"`
+	CreatedAt    string     `json:"createdAt"`
+}
+
 type AuthPayload struct {
 	User *User `json:"user"`
 }
@@ -11,12 +56,24 @@ type BasicResponse struct {
 	Message string `json:"message"`
 }
 
+type CreateAlbumInput struct {
+	Title       string  `json:"title"`
+	Artist      *string `json:"artist,omitempty"`
+	ReleaseDate *string `json:"releaseDate,omitempty"`
+	CoverArtKey *string `json:"coverArtKey,omitempty"`
+}
+
 type GetUser struct {
-	ID          string  `json:"id"`
-	Username    string  `json:"username"`
-	Email       string  `json:"email"`
-	AccountType string  `json:"account_type"`
-	EndingDate  *string `json:"ending_date,omitempty"`
+	ID            string   `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"emailVerified"`
+	PendingEmail  *string  `json:"pendingEmail,omitempty"`
+	TotpEnabled   bool     `json:"totpEnabled"`
+	Role          Role     `json:"role"`
+	Permissions   []string `json:"permissions"`
+	AccountType   string   `json:"account_type"`
+	EndingDate    *string  `json:"ending_date,omitempty"`
 }
 
 type GetUserInfoResponse struct {
@@ -26,13 +83,32 @@ type GetUserInfoResponse struct {
 }
 
 type LoginResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success     bool    `json:"success"`
+	Message     string  `json:"message"`
+	MfaRequired bool    `json:"mfaRequired"`
+	MfaToken    *string `json:"mfaToken,omitempty"`
+}
+
+type MultipartUpload struct {
+	UploadID  string `json:"uploadId"`
+	Key       string `json:"key"`
+	PartSize  int32  `json:"partSize"`
+	ExpiresAt string `json:"expiresAt"`
 }
 
 type Mutation struct {
 }
 
+type PageInfo struct {
+	EndCursor   *string `json:"endCursor,omitempty"`
+	HasNextPage bool    `json:"hasNextPage"`
+}
+
+type Playback struct {
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
 type PresignedURL struct {
 	URL       string `json:"url"`
 	Key       string `json:"key"`
@@ -42,8 +118,383 @@ type PresignedURL struct {
 type Query struct {
 }
 
+type Rendition struct {
+	Preset   string          `json:"preset"`
+	Codec    AudioCodec      `json:"codec"`
+	Bitrate  int32           `json:"bitrate"`
+	Status   RenditionStatus `json:"status"`
+	FileSize *int64          `json:"fileSize,omitempty"`
+}
+
+type Session struct {
+	ID         string  `json:"id"`
+	UserAgent  *string `json:"userAgent,omitempty"`
+	IPAddress  *string `json:"ipAddress,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	LastSeenAt string  `json:"lastSeenAt"`
+	Current    bool    `json:"current"`
+}
+
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthURI"`
+}
+
+type Track struct {
+	ID         uuid.UUID    `json:"id"`
+	AlbumID    *uuid.UUID   `json:"albumId,omitempty"`
+	Position   *int32       `json:"position,omitempty"`
+	Title      string       `json:"title"`
+	Artist     *string      `json:"artist,omitempty"`
+	Genre      *string      `json:"genre,omitempty"`
+	Duration   *int32       `json:"duration,omitempty"`
+	FileSize   *int64       `json:"fileSize,omitempty"`
+	Format     string       `json:"format"`
+	SampleRate *int32       `json:"sampleRate,omitempty"`
+	Channels   *int32       `json:"channels,omitempty"`
+	Bitrate    *int32       `json:"bitrate,omitempty"`
+	Tags       []*TrackTag  `json:"tags"`
+	Renditions []*Rendition `json:"renditions"`
+	Rendition  *Rendition   `json:"rendition,omitempty"`
+	CreatedAt  string       `json:"createdAt"`
+	UpdatedAt  string       `json:"updatedAt"`
+}
+
+type TrackConnection struct {
+	Nodes      []*Track  `json:"nodes"`
+	TotalCount int32     `json:"totalCount"`
+	PageInfo   *PageInfo `json:"pageInfo"`
+}
+
+type TrackFilter struct {
+	Query   *string    `json:"query,omitempty"`
+	AlbumID *uuid.UUID `json:"albumId,omitempty"`
+	Genre   *string    `json:"genre,omitempty"`
+}
+
+type TrackTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type UpdateAlbumInput struct {
+	Title       *string `json:"title,omitempty"`
+	Artist      *string `json:"artist,omitempty"`
+	ReleaseDate *string `json:"releaseDate,omitempty"`
+	CoverArtKey *string `json:"coverArtKey,omitempty"`
+}
+
+type UpdateTrackInput struct {
+	Title           *string    `json:"title,omitempty"`
+	Artist          *string    `json:"artist,omitempty"`
+	Genre           *string    `json:"genre,omitempty"`
+	AlbumID         *uuid.UUID `json:"albumId,omitempty"`
+	RemoveFromAlbum *bool      `json:"removeFromAlbum,omitempty"`
+}
+
+type UploadPart struct {
+	PartNumber int32  `json:"partNumber"`
+	URL        string `json:"url"`
+	ExpiresAt  string `json:"expiresAt"`
+}
+
 type User struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
 }
+
+type UserConnection struct {
+	Nodes      []*AdminUser `json:"nodes"`
+	TotalCount int32        `json:"totalCount"`
+	PageInfo   *PageInfo    `json:"pageInfo"`
+}
+
+type UserFilter struct {
+	Query     *string `json:"query,omitempty"`
+	Role      *Role   `json:"role,omitempty"`
+	Suspended *bool   `json:"suspended,omitempty"`
+}
+
+type AudioCodec string
+
+const (
+	AudioCodecOpus AudioCodec = "OPUS"
+	AudioCodecAac  AudioCodec = "AAC"
+)
+
+var AllAudioCodec = []AudioCodec{
+	AudioCodecOpus,
+	AudioCodecAac,
+}
+
+func (e AudioCodec) IsValid() bool {
+	switch e {
+	case AudioCodecOpus, AudioCodecAac:
+		return true
+	}
+	return false
+}
+
+func (e AudioCodec) String() string {
+	return string(e)
+}
+
+func (e *AudioCodec) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AudioCodec(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AudioCodec", str)
+	}
+	return nil
+}
+
+func (e AudioCodec) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *AudioCodec) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e AudioCodec) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type DeletedUserTracks string
+
+const (
+	DeletedUserTracksDelete    DeletedUserTracks = "DELETE"
+	DeletedUserTracksAnonymize DeletedUserTracks = "ANONYMIZE"
+)
+
+var AllDeletedUserTracks = []DeletedUserTracks{
+	DeletedUserTracksDelete,
+	DeletedUserTracksAnonymize,
+}
+
+func (e DeletedUserTracks) IsValid() bool {
+	switch e {
+	case DeletedUserTracksDelete, DeletedUserTracksAnonymize:
+		return true
+	}
+	return false
+}
+
+func (e DeletedUserTracks) String() string {
+	return string(e)
+}
+
+func (e *DeletedUserTracks) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = DeletedUserTracks(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid DeletedUserTracks", str)
+	}
+	return nil
+}
+
+func (e DeletedUserTracks) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *DeletedUserTracks) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e DeletedUserTracks) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type PlaybackMode string
+
+const (
+	PlaybackModeURL    PlaybackMode = "URL"
+	PlaybackModeCookie PlaybackMode = "COOKIE"
+)
+
+var AllPlaybackMode = []PlaybackMode{
+	PlaybackModeURL,
+	PlaybackModeCookie,
+}
+
+func (e PlaybackMode) IsValid() bool {
+	switch e {
+	case PlaybackModeURL, PlaybackModeCookie:
+		return true
+	}
+	return false
+}
+
+func (e PlaybackMode) String() string {
+	return string(e)
+}
+
+func (e *PlaybackMode) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PlaybackMode(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PlaybackMode", str)
+	}
+	return nil
+}
+
+func (e PlaybackMode) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PlaybackMode) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PlaybackMode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type RenditionStatus string
+
+const (
+	RenditionStatusPending    RenditionStatus = "PENDING"
+	RenditionStatusProcessing RenditionStatus = "PROCESSING"
+	RenditionStatusReady      RenditionStatus = "READY"
+	RenditionStatusFailed     RenditionStatus = "FAILED"
+)
+
+var AllRenditionStatus = []RenditionStatus{
+	RenditionStatusPending,
+	RenditionStatusProcessing,
+	RenditionStatusReady,
+	RenditionStatusFailed,
+}
+
+func (e RenditionStatus) IsValid() bool {
+	switch e {
+	case RenditionStatusPending, RenditionStatusProcessing, RenditionStatusReady, RenditionStatusFailed:
+		return true
+	}
+	return false
+}
+
+func (e RenditionStatus) String() string {
+	return string(e)
+}
+
+func (e *RenditionStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = RenditionStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid RenditionStatus", str)
+	}
+	return nil
+}
+
+func (e RenditionStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *RenditionStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e RenditionStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type Role string
+
+const (
+	RoleListener      Role = "LISTENER"
+	RoleArtist        Role = "ARTIST"
+	RoleLabelAdmin    Role = "LABEL_ADMIN"
+	RolePlatformAdmin Role = "PLATFORM_ADMIN"
+)
+
+var AllRole = []Role{
+	RoleListener,
+	RoleArtist,
+	RoleLabelAdmin,
+	RolePlatformAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleListener, RoleArtist, RoleLabelAdmin, RolePlatformAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Role) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Role) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,10 @@
+package graph
+
+// pageSize unwraps a `first` argument. Clients may pass null explicitly,
+// which skips the schema default; the services then apply their own.
+func pageSize(first *int32) int {
+	if first == nil {
+		return 0
+	}
+	return int(*first)
+}
@@ -13,3 +13,13 @@ type Resolver struct {
 	AuthService  *auth.AuthService
 	MusicService *music.MusicService
 }
+
+// valueOf returns what p points to, or the zero value for an omitted
+// optional argument.
+func valueOf[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
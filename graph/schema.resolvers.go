@@ -8,31 +8,19 @@ import (
 	"context"
 	"fmt"
 	"music-auth/graph/model"
-	"music-auth/internal/middleware"
-	"net/http"
 )
 
 // Register is the resolver for the register field.
 func (r *mutationResolver) Register(ctx context.Context, username string, email string, password string) (*model.AuthPayload, error) {
-	token, user, err := r.AuthService.Register(username, email, password)
+	tokens, user, err := r.AuthService.Register(ctx, username, email, password)
 	if err != nil {
 		return nil, err
 	}
 
-	rw := middleware.GetResponseWriter(ctx)
-	if rw == nil {
-		return nil, fmt.Errorf("could not get response writer")
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return nil, err
 	}
 
-	http.SetCookie(rw, &http.Cookie{
-		Name:     "auth_token",
-		Value:    token,
-		HttpOnly: true,
-		Secure:   false,
-		Path:     "/",
-		SameSite: http.SameSiteLaxMode,
-	})
-
 	return &model.AuthPayload{
 
 		User: &model.User{
@@ -45,24 +33,23 @@ func (r *mutationResolver) Register(ctx context.Context, username string, email
 
 // Login is the resolver for the login field.
 func (r *mutationResolver) Login(ctx context.Context, email string, password string) (*model.LoginResponse, error) {
-	token, err := r.AuthService.Login(email, password)
+	tokens, challenge, err := r.AuthService.Login(ctx, email, password)
 	if err != nil {
 		return nil, err
 	}
 
-	rw := middleware.GetResponseWriter(ctx)
-	if rw == nil {
-		return nil, fmt.Errorf("could not get response writer")
+	if challenge != nil {
+		return &model.LoginResponse{
+			Success:     true,
+			Message:     "Two-factor authentication required",
+			MfaRequired: true,
+			MfaToken:    &challenge.Token,
+		}, nil
 	}
 
-	http.SetCookie(rw, &http.Cookie{
-		Name:     "auth_token",
-		Value:    token,
-		HttpOnly: true,
-		Secure:   false,
-		Path:     "/",
-		SameSite: http.SameSiteLaxMode,
-	})
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return nil, err
+	}
 
 	return &model.LoginResponse{
 		Success: true,
@@ -70,6 +57,79 @@ func (r *mutationResolver) Login(ctx context.Context, email string, password str
 	}, nil
 }
 
+// VerifyMfa is the resolver for the verifyMFA field.
+func (r *mutationResolver) VerifyMfa(ctx context.Context, mfaToken *string, code string) (*model.LoginResponse, error) {
+	token := valueOf(mfaToken)
+	if token == "" {
+		token = readMFACookie(ctx)
+	}
+
+	tokens, err := r.AuthService.VerifyMFA(ctx, token, code)
+	if err != nil {
+		return nil, err
+	}
+
+	clearMFACookie(ctx)
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return nil, err
+	}
+
+	return &model.LoginResponse{
+		Success: true,
+		Message: "Logged in successfully",
+	}, nil
+}
+
+// RefreshSession is the resolver for the refreshSession field.
+func (r *mutationResolver) RefreshSession(ctx context.Context) (*model.BasicResponse, error) {
+	tokens, err := r.AuthService.RefreshSession(ctx, readRefreshCookie(ctx))
+	if err != nil {
+		clearSessionCookies(ctx)
+		return nil, err
+	}
+
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Session refreshed",
+	}, nil
+}
+
+// Logout is the resolver for the logout field.
+func (r *mutationResolver) Logout(ctx context.Context) (*model.BasicResponse, error) {
+	if err := r.AuthService.Logout(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := clearSessionCookies(ctx); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Logged out",
+	}, nil
+}
+
+// LogoutAllDevices is the resolver for the logoutAllDevices field.
+func (r *mutationResolver) LogoutAllDevices(ctx context.Context) (*model.BasicResponse, error) {
+	if err := r.AuthService.LogoutAllDevices(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := clearSessionCookies(ctx); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Logged out of all devices",
+	}, nil
+}
+
 // UpdatePassword is the resolver for the updatePassword field.
 func (r *mutationResolver) UpdatePassword(ctx context.Context, oldPassword string, newPassword string) (*model.BasicResponse, error) {
 	res, err := r.AuthService.UpdatePassword(ctx, oldPassword, newPassword)
@@ -94,7 +154,7 @@ func (r *mutationResolver) UpdateEmail(ctx context.Context, newEmail string) (*m
 
 	return &model.BasicResponse{
 		Success: true,
-		Message: "email update successfully",
+		Message: "verification email sent to the new address",
 	}, nil
 }
 
@@ -112,6 +172,78 @@ func (r *mutationResolver) UpdateUsername(ctx context.Context, newUsername strin
 	}, nil
 }
 
+// VerifyEmail is the resolver for the verifyEmail field.
+func (r *mutationResolver) VerifyEmail(ctx context.Context, token string) (*model.BasicResponse, error) {
+	if err := r.AuthService.VerifyEmail(ctx, token); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "email verified",
+	}, nil
+}
+
+// ResendVerification is the resolver for the resendVerification field.
+func (r *mutationResolver) ResendVerification(ctx context.Context) (*model.BasicResponse, error) {
+	if err := r.AuthService.ResendVerification(ctx); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "verification email sent",
+	}, nil
+}
+
+// RequestPasswordReset is the resolver for the requestPasswordReset field.
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, email string) (*model.BasicResponse, error) {
+	if err := r.AuthService.RequestPasswordReset(ctx, email); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "if an account exists for that email, a reset link has been sent",
+	}, nil
+}
+
+// ResetPassword is the resolver for the resetPassword field.
+func (r *mutationResolver) ResetPassword(ctx context.Context, token string, newPassword string) (*model.BasicResponse, error) {
+	if err := r.AuthService.ResetPassword(ctx, token, newPassword); err != nil {
+		return nil, err
+	}
+
+	clearSessionCookies(ctx)
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Password updated, please log in again",
+	}, nil
+}
+
+// EnrollTotp is the resolver for the enrollTOTP field.
+func (r *mutationResolver) EnrollTotp(ctx context.Context) (*model.TOTPEnrollment, error) {
+	return r.AuthService.EnrollTOTP(ctx)
+}
+
+// ConfirmTotp is the resolver for the confirmTOTP field.
+func (r *mutationResolver) ConfirmTotp(ctx context.Context, code string) ([]string, error) {
+	return r.AuthService.ConfirmTOTP(ctx, code)
+}
+
+// DisableTotp is the resolver for the disableTOTP field.
+func (r *mutationResolver) DisableTotp(ctx context.Context, password string, code string) (*model.BasicResponse, error) {
+	if err := r.AuthService.DisableTOTP(ctx, password, code); err != nil {
+		return nil, err
+	}
+
+	return &model.BasicResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	}, nil
+}
+
 // GetUserInfo is the resolver for the getUserInfo field.
 func (r *queryResolver) GetUserInfo(ctx context.Context) (*model.GetUserInfoResponse, error) {
 	user, err := r.AuthService.GetUserInfo(ctx)
@@ -129,6 +261,11 @@ func (r *queryResolver) GetUserInfo(ctx context.Context) (*model.GetUserInfoResp
 	}, nil
 }
 
+// ListSessions is the resolver for the listSessions field.
+func (r *queryResolver) ListSessions(ctx context.Context) ([]*model.Session, error) {
+	return r.AuthService.ListSessions(ctx)
+}
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
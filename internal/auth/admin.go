@@ -0,0 +1,509 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"music-auth/graph/model"
+	"music-auth/internal/common"
+	"music-auth/internal/middleware"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxUsersPageSize = 100
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrActingOnSelf  = errors.New("you cannot do this to your own account")
+	ErrImpersonating = errors.New("not allowed while impersonating a user")
+	ErrOutranked     = errors.New("you cannot do this to a user above you")
+)
+
+// SearchUsers lists the users of the caller's tenant, newest first, with
+// keyset pagination on (created_at, id).
+func (a *AuthService) SearchUsers(ctx context.Context, filter *model.UserFilter, first int, after *string) (*model.UserConnection, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if first <= 0 || first > maxUsersPageSize {
+		first = maxUsersPageSize
+	}
+
+	where := []string{"u.tenant_id = $1"}
+	args := []any{claims.Tenant}
+
+	if filter != nil {
+		if filter.Query != nil && *filter.Query != "" {
+			args = append(args, "%"+common.EscapeLike(*filter.Query)+"%")
+			where = append(where, fmt.Sprintf("(u.email ILIKE $%d OR u.username ILIKE $%d)", len(args), len(args)))
+		}
+		if filter.Role != nil {
+			args = append(args, strings.ToLower(string(*filter.Role)))
+			where = append(where, fmt.Sprintf("u.role = $%d", len(args)))
+		}
+		if filter.Suspended != nil {
+			if *filter.Suspended {
+				where = append(where, "u.suspended_at IS NOT NULL")
+			} else {
+				where = append(where, "u.suspended_at IS NULL")
+			}
+		}
+	}
+
+	var total int32
+	countQuery := `SELECT count(*) FROM users u WHERE ` + strings.Join(where, " AND ")
+	if err := a.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("db error: %w", err)
+	}
+
+	if after != nil && *after != "" {
+		createdAt, id, err := common.DecodeCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		where = append(where, fmt.Sprintf("(u.created_at, u.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	args = append(args, first+1)
+	query := `
+        SELECT u.id, u.username, u.email, u.email_verified, u.role, u.suspended_at, u.suspended_reason,
+               u.deleted_at IS NOT NULL, (SELECT count(*) FROM tracks t WHERE t.user_id = u.id), u.created_at
+        FROM users u
+        WHERE ` + strings.Join(where, " AND ") + fmt.Sprintf(`
+        ORDER BY u.created_at DESC, u.id DESC
+        LIMIT $%d`, len(args))
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("db error: %w", err)
+	}
+	defer rows.Close()
+
+	conn := &model.UserConnection{Nodes: []*model.AdminUser{}, TotalCount: total, PageInfo: &model.PageInfo{}}
+	var lastCreated time.Time
+
+	for rows.Next() {
+		if len(conn.Nodes) == first {
+			conn.PageInfo.HasNextPage = true
+			break
+		}
+
+		var (
+			u           model.AdminUser
+			role        string
+			suspendedAt sql.NullTime
+			reason      sql.NullString
+			createdAt   time.Time
+		)
+		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerified, &role, &suspendedAt, &reason,
+			&u.Deleted, &u.TrackCount, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("db error: %w", err)
+		}
+
+		u.Role = model.Role(strings.ToUpper(role))
+		u.Suspended = suspendedAt.Valid
+		if suspendedAt.Valid {
+			ts := suspendedAt.Time.Format(time.RFC3339)
+			u.SuspendedAt = &ts
+		}
+		if reason.Valid {
+			u.SuspendedReason = &reason.String
+		}
+		u.CreatedAt = createdAt.Format(time.RFC3339)
+		lastCreated = createdAt
+
+		conn.Nodes = append(conn.Nodes, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db error: %w", err)
+	}
+
+	if n := len(conn.Nodes); n > 0 {
+		cursor := common.EncodeCursor(lastCreated, conn.Nodes[n-1].ID)
+		conn.PageInfo.EndCursor = &cursor
+	}
+
+	return conn, nil
+}
+
+// SuspendUser blocks the account from logging in, ends its sessions and
+// revokes the refresh tokens third-party clients hold for it.
+func (a *AuthService) SuspendUser(ctx context.Context, userID uuid.UUID, reason *string) error {
+	claims := middleware.CurrentUser(ctx)
+
+	if userID == claims.UserID {
+		return ErrActingOnSelf
+	}
+
+	return a.adminTx(ctx, func(tx *sql.Tx) error {
+		if err := a.lockTargetUser(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `
+            UPDATE users SET suspended_at = now(), suspended_reason = $1, updated_at = now()
+            WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL
+        `
+		if err := expectOne(tx.ExecContext(ctx, query, reason, userID, claims.Tenant)); err != nil {
+			return err
+		}
+
+		if err := a.revokeSessions(ctx, tx, userID, nil, nil); err != nil {
+			return err
+		}
+
+		return a.audit(ctx, tx, "user.suspend", userID, map[string]any{"reason": reason})
+	})
+}
+
+func (a *AuthService) ReinstateUser(ctx context.Context, userID uuid.UUID) error {
+	claims := middleware.CurrentUser(ctx)
+
+	return a.adminTx(ctx, func(tx *sql.Tx) error {
+		query := `
+            UPDATE users SET suspended_at = NULL, suspended_reason = NULL, updated_at = now()
+            WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+        `
+		if err := expectOne(tx.ExecContext(ctx, query, userID, claims.Tenant)); err != nil {
+			return err
+		}
+
+		return a.audit(ctx, tx, "user.reinstate", userID, nil)
+	})
+}
+
+// ForceLogout revokes every session of the user, including impersonation
+// sessions.
+func (a *AuthService) ForceLogout(ctx context.Context, userID uuid.UUID) error {
+	return a.adminTx(ctx, func(tx *sql.Tx) error {
+		if err := a.lockTargetUser(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		if err := a.revokeSessions(ctx, tx, userID, nil, nil); err != nil {
+			return err
+		}
+
+		return a.audit(ctx, tx, "user.force_logout", userID, nil)
+	})
+}
+
+// SetRole changes the user's role. Their sessions are revoked so the new
+// role cannot be outlived by tokens carrying the old one.
+func (a *AuthService) SetRole(ctx context.Context, userID uuid.UUID, role string) error {
+	claims := middleware.CurrentUser(ctx)
+
+	if !common.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if userID == claims.UserID {
+		return ErrActingOnSelf
+	}
+	if !claims.HasRole(role) {
+		return errors.New("you cannot grant a role above your own")
+	}
+
+	return a.adminTx(ctx, func(tx *sql.Tx) error {
+		var previous string
+		query := `SELECT role FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, query, userID, claims.Tenant).Scan(&previous); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("internal server error")
+		}
+
+		if !claims.HasRole(previous) {
+			return errors.New("you cannot change the role of a user above you")
+		}
+
+		query = `UPDATE users SET role = $1, updated_at = now() WHERE id = $2`
+		if _, err := tx.ExecContext(ctx, query, role, userID); err != nil {
+			return fmt.Errorf("internal server error")
+		}
+
+		if err := a.revokeSessions(ctx, tx, userID, nil, nil); err != nil {
+			return err
+		}
+
+		return a.audit(ctx, tx, "user.set_role", userID, map[string]any{"from": previous, "to": role})
+	})
+}
+
+// ImpersonateUser opens a session as the user on behalf of the calling admin.
+// Tokens of that session carry the admin in the "act" claim, and changes to
+// the account's credentials are refused while it is in use.
+func (a *AuthService) ImpersonateUser(ctx context.Context, userID uuid.UUID, reason string) (*TokenPair, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if claims.Actor != nil {
+		return nil, ErrImpersonating
+	}
+	if userID == claims.UserID {
+		return nil, ErrActingOnSelf
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.New("a reason is required")
+	}
+
+	var user User
+	query := `
+        SELECT id, tenant_id, username, email, role
+        FROM users
+        WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL AND suspended_at IS NULL
+    `
+	err := a.db.QueryRowContext(ctx, query, userID, claims.Tenant).Scan(
+		&user.ID, &user.TenantID, &user.Username, &user.Email, &user.Role,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	if user.Role == common.RolePlatformAdmin {
+		return nil, errors.New("platform admins cannot be impersonated")
+	}
+
+	// Record the intent before handing out anything.
+	if err := a.audit(ctx, a.db, "user.impersonate", userID, map[string]any{"reason": reason}); err != nil {
+		return nil, err
+	}
+
+	return a.createSession(ctx, &user, uuid.NullUUID{UUID: claims.UserID, Valid: true})
+}
+
+// DeleteUser removes an account. With keepTracks the user row is kept as an
+// anonymous placeholder owning the tracks; otherwise the tracks and albums
+// go with it and the storage keys of the deleted tracks are returned so the
+// caller can remove the files.
+func (a *AuthService) DeleteUser(ctx context.Context, userID uuid.UUID, keepTracks bool) ([]string, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if userID == claims.UserID {
+		return nil, ErrActingOnSelf
+	}
+
+	var keys []string
+
+	err := a.adminTx(ctx, func(tx *sql.Tx) error {
+		if err := a.lockTargetUser(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		if keepTracks {
+			if err := anonymizeUser(ctx, tx, userID); err != nil {
+				return err
+			}
+			return a.audit(ctx, tx, "user.delete", userID, map[string]any{"tracks": "anonymized"})
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT key FROM tracks WHERE user_id = $1 AND tenant_id = $2`, userID, claims.Tenant)
+		if err != nil {
+			return fmt.Errorf("internal server error")
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return fmt.Errorf("internal server error")
+			}
+			keys = append(keys, key)
+		}
+		rows.Close()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1 AND tenant_id = $2`, userID, claims.Tenant); err != nil {
+			return fmt.Errorf("internal server error")
+		}
+
+		return a.audit(ctx, tx, "user.delete", userID, map[string]any{"tracks": "deleted", "track_count": len(keys)})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// anonymizeUser scrubs everything that identifies the person while keeping
+// the row, and with it their tracks.
+func anonymizeUser(ctx context.Context, tx *sql.Tx, userID uuid.UUID) error {
+	placeholder := "deleted-" + strings.ReplaceAll(userID.String(), "-", "")
+
+	query := `
+        UPDATE users
+        SET username = $1, email = $1 || '@deleted.invalid', pending_email = NULL, password = NULL,
+            email_verified = false, role = 'listener', suspended_at = now(), suspended_reason = 'deleted',
+            deleted_at = now(), updated_at = now()
+        WHERE id = $2
+    `
+	if _, err := tx.ExecContext(ctx, query, placeholder, userID); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	for _, q := range []string{
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM totp_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM email_verification_tokens WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+		`DELETE FROM mfa_challenges WHERE user_id = $1`,
+		`DELETE FROM oauth_consents WHERE user_id = $1`,
+		`DELETE FROM oauth_refresh_tokens WHERE user_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+			return fmt.Errorf("internal server error")
+		}
+	}
+
+	return nil
+}
+
+// AuditLog returns the newest entries of the caller's tenant, optionally only
+// those about one user.
+func (a *AuthService) AuditLog(ctx context.Context, userID *uuid.UUID, first int) ([]*model.AuditEntry, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if first <= 0 || first > maxUsersPageSize {
+		first = maxUsersPageSize
+	}
+
+	query := `
+        SELECT id, actor_id, action, target_user_id, details, ip_address, created_at
+        FROM admin_audit_log
+        WHERE tenant_id = $1 AND ($2::uuid IS NULL OR target_user_id = $2)
+        ORDER BY created_at DESC
+        LIMIT $3
+    `
+
+	var target uuid.NullUUID
+	if userID != nil {
+		target = uuid.NullUUID{UUID: *userID, Valid: true}
+	}
+
+	rows, err := a.db.QueryContext(ctx, query, claims.Tenant, target, first)
+	if err != nil {
+		return nil, fmt.Errorf("db error: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*model.AuditEntry{}
+	for rows.Next() {
+		var (
+			e               model.AuditEntry
+			actor, targetID uuid.NullUUID
+			ip              sql.NullString
+			createdAt       time.Time
+		)
+		if err := rows.Scan(&e.ID, &actor, &e.Action, &targetID, &e.Details, &ip, &createdAt); err != nil {
+			return nil, fmt.Errorf("db error: %w", err)
+		}
+		if actor.Valid {
+			e.ActorID = &actor.UUID
+		}
+		if targetID.Valid {
+			e.TargetUserID = &targetID.UUID
+		}
+		if ip.Valid {
+			e.IPAddress = &ip.String
+		}
+		e.CreatedAt = createdAt.Format(time.RFC3339)
+		entries = append(entries, &e)
+	}
+
+	return entries, rows.Err()
+}
+
+// audit records an admin action. It runs in the action's transaction so an
+// action is never applied without its entry.
+func (a *AuthService) audit(ctx context.Context, q execer, action string, target uuid.UUID, details map[string]any) error {
+	claims := middleware.CurrentUser(ctx)
+
+	if details == nil {
+		details = map[string]any{}
+	}
+	if claims.Actor != nil {
+		details["impersonated_by"] = claims.Actor.Subject
+	}
+
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	var ip sql.NullString
+	if r := middleware.GetRequest(ctx); r != nil {
+		ip = sql.NullString{String: middleware.ClientIP(r), Valid: true}
+	}
+
+	query := `
+        INSERT INTO admin_audit_log (tenant_id, actor_id, action, target_user_id, details, ip_address)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+	if _, err := q.ExecContext(ctx, query, claims.Tenant, claims.UserID, action, target, payload, ip); err != nil {
+		return fmt.Errorf("unable to write audit log: %w", err)
+	}
+
+	return nil
+}
+
+func (a *AuthService) adminTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	return nil
+}
+
+// lockTargetUser makes sure the target is a user of the caller's tenant who
+// does not outrank the caller, the check SetRole makes before a role change.
+func (a *AuthService) lockTargetUser(ctx context.Context, tx *sql.Tx, userID uuid.UUID) error {
+	claims := middleware.CurrentUser(ctx)
+
+	var role string
+	query := `SELECT role FROM users WHERE id = $1 AND tenant_id = $2 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, userID, claims.Tenant).Scan(&role); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("internal server error")
+	}
+
+	if !claims.HasRole(role) {
+		return ErrOutranked
+	}
+
+	return nil
+}
+
+func expectOne(res sql.Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"music-auth/internal/middleware"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrIdentityLinkedElsewhere = errors.New("this account is already linked to another user")
+	ErrEmailNeedsLinking       = errors.New("an account with this email already exists, log in with your password and link it from there")
+	ErrIdentityMissingEmail    = errors.New("the provider did not share an email address")
+)
+
+// ExternalIdentity is what a social login provider tells us about the user.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// LoginWithIdentity signs in the user behind an external identity, creating
+// the link (and, if needed, the user) on first use:
+//
+//   - a known (provider, subject) logs in its linked user;
+//   - with linkTo set the identity is attached to that (logged in) user;
+//   - a provider-verified email matching an existing user with a verified
+//     email links to it;
+//   - otherwise a new passwordless user is created.
+//
+// A matching email that is unverified on either side is refused. Linking an
+// address the provider hasn't verified would let anyone who controls the
+// provider account take over ours; linking to an account whose address we
+// haven't verified would hand the victim an account someone else registered
+// (and still holds the password of) in their name.
+func (a *AuthService) LoginWithIdentity(ctx context.Context, identity ExternalIdentity, linkTo uuid.UUID) (*TokenPair, *MFAChallenge, error) {
+	if identity.Provider == "" || identity.Subject == "" {
+		return nil, nil, errors.New("invalid identity")
+	}
+
+	tenant := middleware.GetTenant(ctx)
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	query := `SELECT user_id FROM user_identities WHERE tenant_id = $1 AND provider = $2 AND subject = $3 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, tenant, identity.Provider, identity.Subject).Scan(&userID)
+
+	switch {
+	case err == nil:
+		if linkTo != uuid.Nil && linkTo != userID {
+			return nil, nil, ErrIdentityLinkedElsewhere
+		}
+		query = `
+            UPDATE user_identities SET last_login_at = now(), email = $1
+            WHERE tenant_id = $2 AND provider = $3 AND subject = $4
+        `
+		if _, err := tx.ExecContext(ctx, query, nullString(identity.Email), tenant, identity.Provider, identity.Subject); err != nil {
+			return nil, nil, fmt.Errorf("internal server error")
+		}
+
+	case err != sql.ErrNoRows:
+		return nil, nil, fmt.Errorf("internal server error")
+
+	case linkTo != uuid.Nil:
+		userID = linkTo
+		if err := linkIdentity(ctx, tx, tenant, userID, identity); err != nil {
+			return nil, nil, err
+		}
+
+	default:
+		if identity.Email == "" {
+			return nil, nil, ErrIdentityMissingEmail
+		}
+
+		var emailVerified bool
+		query = `SELECT id, email_verified FROM users WHERE tenant_id = $1 AND email = $2`
+		err = tx.QueryRowContext(ctx, query, tenant, identity.Email).Scan(&userID, &emailVerified)
+		switch {
+		case err == nil:
+			if !identity.EmailVerified || !emailVerified {
+				return nil, nil, ErrEmailNeedsLinking
+			}
+			if err := linkIdentity(ctx, tx, tenant, userID, identity); err != nil {
+				return nil, nil, err
+			}
+
+		case err == sql.ErrNoRows:
+			userID, err = createIdentityUser(ctx, tx, tenant, identity)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := linkIdentity(ctx, tx, tenant, userID, identity); err != nil {
+				return nil, nil, err
+			}
+
+		default:
+			return nil, nil, fmt.Errorf("internal server error")
+		}
+	}
+
+	var user User
+	query = `SELECT id, tenant_id, username, email FROM users WHERE id = $1 AND tenant_id = $2`
+	if err := tx.QueryRowContext(ctx, query, userID, tenant).Scan(&user.ID, &user.TenantID, &user.Username, &user.Email); err != nil {
+		return nil, nil, fmt.Errorf("internal server error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("internal server error")
+	}
+
+	mfa, err := a.totpEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("internal server error")
+	}
+
+	if mfa {
+		challenge, err := a.createMFAChallenge(ctx, user.ID)
+		return nil, challenge, err
+	}
+
+	tokens, err := a.startSession(ctx, &user)
+	return tokens, nil, err
+}
+
+// linkIdentity fails on the (tenant_id, user_id) foreign key if userID is a
+// user of another tenant.
+func linkIdentity(ctx context.Context, q execer, tenant string, userID uuid.UUID, identity ExternalIdentity) error {
+	query := `INSERT INTO user_identities (tenant_id, user_id, provider, subject, email) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := q.ExecContext(ctx, query, tenant, userID, identity.Provider, identity.Subject, nullString(identity.Email))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "user_identities_user_provider_key" {
+			return fmt.Errorf("a different %s account is already linked", identity.Provider)
+		}
+		return fmt.Errorf("could not link account: %w", err)
+	}
+
+	return nil
+}
+
+func createIdentityUser(ctx context.Context, q queryer, tenant string, identity ExternalIdentity) (uuid.UUID, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = sanitizeUsername(base)
+
+	username := base
+	for attempt := 0; ; attempt++ {
+		var taken bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE tenant_id = $1 AND username = $2)`
+		err := q.QueryRowContext(ctx, query, tenant, username).Scan(&taken)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("internal server error")
+		}
+		if !taken {
+			break
+		}
+		if attempt == 5 {
+			return uuid.Nil, errors.New("username already taken")
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("internal server error")
+		}
+		username = fmt.Sprintf("%s%04d", base, n.Int64())
+	}
+
+	query := `
+        INSERT INTO users (tenant_id, username, email, password, email_verified)
+        VALUES ($1, $2, $3, NULL, $4)
+        RETURNING id
+    `
+
+	var userID uuid.UUID
+	err := q.QueryRowContext(ctx, query, tenant, username, identity.Email, identity.EmailVerified).Scan(&userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Constraint {
+			case "users_email_key":
+				return uuid.Nil, errors.New("email already registered")
+			case "users_username_key":
+				return uuid.Nil, errors.New("username already taken")
+			}
+		}
+		return uuid.Nil, fmt.Errorf("could not insert user: %w", err)
+	}
+
+	return userID, nil
+}
+
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			b.WriteRune(r)
+		}
+		if b.Len() >= 30 {
+			break
+		}
+	}
+	if b.Len() < 3 {
+		return "user"
+	}
+	return b.String()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -6,27 +6,41 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-func (a *AuthService) GenerateToken(user *User) (string, error) {
+func (a *AuthService) GenerateToken(user *User, sessionID uuid.UUID) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL)
+
 	claims := &common.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Tenant : "music-store",
+		UserID:      user.ID,
+		Email:       user.Email,
+		Tenant:      user.TenantID,
+		Role:        user.Role,
+		Permissions: user.Permissions,
+		SessionID:   sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(a.jwtSecret)
+	if user.Impersonator.Valid {
+		claims.Actor = &common.Actor{Subject: user.Impersonator.UUID}
+	}
+
+	signed, err := a.keyring.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiresAt, nil
 }
 
 func (a *AuthService) ParseToken(tokenStr string) (*common.Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &common.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return a.jwtSecret, nil
-	})
+	token, err := a.keyring.Parse(tokenStr, &common.Claims{})
 	if err != nil {
 		return nil, err
 	}
@@ -38,4 +52,3 @@ func (a *AuthService) ParseToken(tokenStr string) (*common.Claims, error) {
 
 	return claims, nil
 }
-
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"music-auth/graph/model"
+	"music-auth/internal/middleware"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount       = 10
+)
+
+var (
+	ErrInvalidMFAChallenge = errors.New("login session expired, please log in again")
+	ErrInvalidMFACode      = errors.New("invalid authentication code")
+)
+
+// MFAChallenge is returned by Login instead of tokens when the account has
+// TOTP enabled. The token is exchanged for a session by VerifyMFA.
+type MFAChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+type queryer interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (a *AuthService) totpEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var enabled bool
+	query := `SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)`
+	err := a.db.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+func (a *AuthService) createMFAChallenge(ctx context.Context, userID uuid.UUID) (*MFAChallenge, error) {
+	token, err := a.newSignedToken(purposeMFAChallenge)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+
+	query := `INSERT INTO mfa_challenges (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	if _, err := a.db.ExecContext(ctx, query, userID, hashToken(token), expiresAt); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	return &MFAChallenge{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// VerifyMFA completes a two-step login with either a TOTP code or an unused
+// recovery code.
+func (a *AuthService) VerifyMFA(ctx context.Context, mfaToken, code string) (*TokenPair, error) {
+	if !a.verifySignedToken(purposeMFAChallenge, mfaToken) {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT c.id, c.attempts, c.expires_at, c.used_at, u.id, u.tenant_id, u.username, u.email
+        FROM mfa_challenges c
+        JOIN users u ON u.id = c.user_id
+        WHERE c.token_hash = $1 AND u.tenant_id = $2
+        FOR UPDATE OF c
+    `
+
+	var (
+		challengeID uuid.UUID
+		attempts    int
+		expiresAt   time.Time
+		usedAt      sql.NullTime
+		user        User
+	)
+
+	err = tx.QueryRowContext(ctx, query, hashToken(mfaToken), middleware.GetTenant(ctx)).Scan(
+		&challengeID, &attempts, &expiresAt, &usedAt, &user.ID, &user.TenantID, &user.Username, &user.Email,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidMFAChallenge
+		}
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	if usedAt.Valid || attempts >= mfaChallengeMaxAttempts || time.Now().After(expiresAt) {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	ok, err := a.checkSecondFactor(ctx, tx, user.ID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	account := accountKey("login", user.TenantID, user.Email)
+
+	if !ok {
+		recordFailure(ctx, a.limits.loginAccount, account)
+
+		// Count the failure even though the login fails.
+		_, err := tx.ExecContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1`, challengeID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("internal server error")
+		}
+		return nil, ErrInvalidMFACode
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE mfa_challenges SET used_at = now() WHERE id = $1`, challengeID); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	a.resetLoginLimit(ctx, account)
+
+	return a.startSession(ctx, &user)
+}
+
+// checkSecondFactor accepts a current TOTP code (each time step only once) or
+// consumes a recovery code.
+func (a *AuthService) checkSecondFactor(ctx context.Context, q queryer, userID uuid.UUID, code string) (bool, error) {
+	query := `
+        SELECT secret_ciphertext, last_used_step
+        FROM user_totp
+        WHERE user_id = $1 AND confirmed_at IS NOT NULL
+        FOR UPDATE
+    `
+
+	var (
+		ciphertext string
+		lastStep   sql.NullInt64
+	)
+
+	err := q.QueryRowContext(ctx, query, userID).Scan(&ciphertext, &lastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("internal server error")
+	}
+
+	secret, err := a.openTOTPSecret(ciphertext)
+	if err != nil {
+		return false, fmt.Errorf("internal server error")
+	}
+
+	if step, ok := checkTOTP(secret, code, time.Now()); ok {
+		if lastStep.Valid && step <= lastStep.Int64 {
+			return false, nil
+		}
+		_, err := q.ExecContext(ctx, `UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2`, step, userID)
+		if err != nil {
+			return false, fmt.Errorf("internal server error")
+		}
+		return true, nil
+	}
+
+	query = `
+        UPDATE totp_recovery_codes SET used_at = now()
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `
+
+	res, err := q.ExecContext(ctx, query, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, fmt.Errorf("internal server error")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("internal server error")
+	}
+
+	return n == 1, nil
+}
+
+// EnrollTOTP creates (or replaces) an unconfirmed TOTP secret for the caller.
+// It only takes effect after ConfirmTOTP.
+func (a *AuthService) EnrollTOTP(ctx context.Context) (*model.TOTPEnrollment, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if claims.Actor != nil {
+		return nil, ErrImpersonating
+	}
+
+	enabled, err := a.totpEnabled(ctx, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+	if enabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	sealed, err := a.sealTOTPSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	query := `
+        INSERT INTO user_totp (user_id, secret_ciphertext)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret_ciphertext = EXCLUDED.secret_ciphertext, last_used_step = NULL, created_at = now()
+        WHERE user_totp.confirmed_at IS NULL
+    `
+
+	if _, err := a.db.ExecContext(ctx, query, claims.UserID, sealed); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	return &model.TOTPEnrollment{
+		Secret:     secret,
+		OtpauthURI: totpURI(claims.Tenant, secret, claims.Email),
+	}, nil
+}
+
+// ConfirmTOTP turns on two-factor authentication once the user proves their
+// authenticator produces valid codes, and returns fresh recovery codes. The
+// codes are only ever shown here.
+func (a *AuthService) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT secret_ciphertext
+        FROM user_totp
+        WHERE user_id = $1 AND confirmed_at IS NULL
+        FOR UPDATE
+    `
+
+	var ciphertext string
+	err = tx.QueryRowContext(ctx, query, claims.UserID).Scan(&ciphertext)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("no pending two-factor enrollment, call enrollTOTP first")
+		}
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	secret, err := a.openTOTPSecret(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	step, ok := checkTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	query = `UPDATE user_totp SET confirmed_at = now(), last_used_step = $1 WHERE user_id = $2`
+	if _, err := tx.ExecContext(ctx, query, step, claims.UserID); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	codes, err := a.replaceRecoveryCodes(ctx, tx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP requires both the password and a second factor so that a
+// hijacked session alone cannot strip 2FA from the account.
+func (a *AuthService) DisableTOTP(ctx context.Context, password, code string) error {
+	claims := middleware.CurrentUser(ctx)
+
+	if claims.Actor != nil {
+		return ErrImpersonating
+	}
+
+	var hash string
+	query := `SELECT COALESCE(password, '') FROM users WHERE id = $1 AND tenant_id = $2`
+	err := a.db.QueryRowContext(ctx, query, claims.UserID, claims.Tenant).Scan(&hash)
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	if !CheckPasswordHash(password, hash) {
+		return errors.New("invalid password")
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	ok, err := a.checkSecondFactor(ctx, tx, claims.UserID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, claims.UserID); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, claims.UserID); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	return nil
+}
+
+func (a *AuthService) replaceRecoveryCodes(ctx context.Context, q execer, userID uuid.UUID) ([]string, error) {
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	if _, err := q.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	for _, code := range codes {
+		query := `INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := q.ExecContext(ctx, query, userID, hashToken(code)); err != nil {
+			return nil, fmt.Errorf("internal server error")
+		}
+	}
+
+	return codes, nil
+}
@@ -4,7 +4,14 @@ import "github.com/google/uuid"
 
 type User struct {
 	ID       uuid.UUID
+	TenantID string
 	Email    string
 	Username string
 	Password string
+
+	Role        string
+	Permissions []string
+
+	// Impersonator is the admin behind an impersonation session.
+	Impersonator uuid.NullUUID
 }
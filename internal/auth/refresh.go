@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"music-auth/internal/middleware"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrAccountSuspended    = errors.New("this account has been suspended")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, please log in again")
+)
+
+// TokenPair is what a successful login, registration or refresh hands back
+// to the client: a short-lived access JWT plus an opaque refresh token.
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// generateOpaqueToken returns 32 random bytes encoded as URL-safe base64.
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored in Postgres in place of an opaque token, so a
+// database leak does not hand out live credentials.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens signs a new access token for the session and stores a fresh
+// refresh token in the session's family. The role is re-read every time, so
+// a promotion or demotion applies from the next refresh, and suspended
+// accounts get nothing.
+func (a *AuthService) issueTokens(ctx context.Context, q queryer, user *User, sessionID uuid.UUID) (*TokenPair, error) {
+	suspended, err := loadTokenClaims(ctx, q, user, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load role: %w", err)
+	}
+	if suspended {
+		return nil, ErrAccountSuspended
+	}
+
+	access, accessExp, err := a.GenerateToken(user, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	refresh, err := generateOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	refreshExp := time.Now().Add(RefreshTokenTTL)
+
+	query := `
+        INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `
+
+	_, err = q.ExecContext(ctx, query, user.ID, sessionID, hashToken(refresh), refreshExp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	_, err = q.ExecContext(ctx, `UPDATE sessions SET expires_at = $1 WHERE id = $2`, refreshExp, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extend session: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExp,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExp,
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. Each refresh
+// token is single use: presenting one that was already rotated means it
+// leaked, so the whole family (i.e. the session) is revoked.
+func (a *AuthService) RefreshSession(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT rt.id, rt.family_id, rt.expires_at, rt.used_at, rt.revoked_at, s.revoked_at,
+               u.id, u.tenant_id, u.username, u.email
+        FROM refresh_tokens rt
+        JOIN sessions s ON s.id = rt.family_id
+        JOIN users u ON u.id = rt.user_id
+        WHERE rt.token_hash = $1 AND u.tenant_id = $2
+        FOR UPDATE OF rt, s
+    `
+
+	var (
+		tokenID          uuid.UUID
+		familyID         uuid.UUID
+		expiresAt        time.Time
+		usedAt           sql.NullTime
+		revokedAt        sql.NullTime
+		sessionRevokedAt sql.NullTime
+		user             User
+	)
+
+	err = tx.QueryRowContext(ctx, query, hashToken(refreshToken), middleware.GetTenant(ctx)).Scan(
+		&tokenID, &familyID, &expiresAt, &usedAt, &revokedAt, &sessionRevokedAt,
+		&user.ID, &user.TenantID, &user.Username, &user.Email,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	if sessionRevokedAt.Valid {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if usedAt.Valid || revokedAt.Valid {
+		if err := a.revokeSessions(ctx, tx, user.ID, &familyID, nil); err != nil {
+			return nil, fmt.Errorf("internal server error")
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("internal server error")
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE id = $1`, tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	tokens, err := a.issueTokens(ctx, tx, &user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	return tokens, nil
+}
+
+// loadTokenClaims fills in what the access token says about the user beyond
+// their identity: role, permissions and, for impersonation sessions, the
+// admin behind it.
+func loadTokenClaims(ctx context.Context, q queryer, user *User, sessionID uuid.UUID) (bool, error) {
+	query := `
+        SELECT u.role,
+               ARRAY(SELECT permission FROM role_permissions rp WHERE rp.role = u.role ORDER BY permission),
+               s.impersonator_id,
+               u.suspended_at IS NOT NULL
+        FROM users u
+        JOIN sessions s ON s.user_id = u.id
+        WHERE u.id = $1 AND s.id = $2
+    `
+
+	var suspended bool
+	err := q.QueryRowContext(ctx, query, user.ID, sessionID).Scan(
+		&user.Role, pq.Array(&user.Permissions), &user.Impersonator, &suspended,
+	)
+	return suspended, err
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"music-auth/internal/mail"
+	"music-auth/internal/middleware"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	passwordResetTokenTTL      = time.Hour
+	passwordResetRequestWindow = time.Minute
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset link")
+
+// RequestPasswordReset mails a reset link if the address belongs to an
+// account. It never tells the caller whether it did: the resolver answers the
+// same way either way, and the mail goes out in the background so response
+// time does not give it away either.
+func (a *AuthService) RequestPasswordReset(ctx context.Context, email string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
+
+	if err := throttled(ctx, a.limits.resetIP, ipKey(ctx, "reset"), true); err != nil {
+		return err
+	}
+
+	query := `
+        SELECT u.id,
+               (SELECT max(created_at) FROM password_reset_tokens WHERE user_id = u.id)
+        FROM users u
+        WHERE u.tenant_id = $1 AND u.email = $2
+    `
+
+	var (
+		userID   uuid.UUID
+		lastSent sql.NullTime
+	)
+
+	err := a.db.QueryRowContext(ctx, query, middleware.GetTenant(ctx), email).Scan(&userID, &lastSent)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("password reset lookup", "error", err)
+		}
+		return nil
+	}
+
+	if lastSent.Valid && time.Since(lastSent.Time) < passwordResetRequestWindow {
+		return nil
+	}
+
+	go func(ctx context.Context) {
+		if err := a.sendPasswordReset(ctx, userID, email); err != nil {
+			slog.Error("send password reset email", "user_id", userID, "error", err)
+		}
+	}(context.WithoutCancel(ctx))
+
+	return nil
+}
+
+func (a *AuthService) sendPasswordReset(ctx context.Context, userID uuid.UUID, email string) error {
+	token, err := a.newSignedToken(purposePasswordReset)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	query := `
+        INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `
+
+	_, err = a.db.ExecContext(ctx, query, userID, hashToken(token), time.Now().Add(passwordResetTokenTTL))
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	link := a.appURL + "/reset-password?token=" + url.QueryEscape(token)
+
+	return a.queueMail(ctx, mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Text: "Someone asked to reset the password for your music-store account. Open the link below to choose a new one:\n\n" +
+			link + "\n\nThe link expires in one hour. If this wasn't you, you can ignore this email.\n",
+	})
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+// and signs the user out everywhere. Invalid tokens count against the
+// client's reset limit.
+func (a *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if newPassword == "" {
+		return errors.New("new password is required")
+	}
+
+	ip := ipKey(ctx, "reset")
+	if err := throttled(ctx, a.limits.resetIP, ip, false); err != nil {
+		return err
+	}
+
+	err := a.resetPassword(ctx, token, newPassword)
+	if errors.Is(err, ErrInvalidResetToken) {
+		recordFailure(ctx, a.limits.resetIP, ip)
+	}
+	return err
+}
+
+func (a *AuthService) resetPassword(ctx context.Context, token, newPassword string) error {
+
+	if !a.verifySignedToken(purposePasswordReset, token) {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("unable to update password, try again later")
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT t.user_id, t.expires_at, t.used_at
+        FROM password_reset_tokens t
+        JOIN users u ON u.id = t.user_id
+        WHERE t.token_hash = $1 AND u.tenant_id = $2
+        FOR UPDATE OF t
+    `
+
+	var (
+		userID    uuid.UUID
+		expiresAt time.Time
+		usedAt    sql.NullTime
+	)
+
+	err = tx.QueryRowContext(ctx, query, hashToken(token), middleware.GetTenant(ctx)).Scan(&userID, &expiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("internal server error")
+	}
+
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET password = $1, updated_at = now() WHERE id = $2`, hashedPassword, userID)
+	if err != nil {
+		return fmt.Errorf("unable to update password, try again later")
+	}
+
+	query = `UPDATE password_reset_tokens SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	if err := a.revokeSessions(ctx, tx, userID, nil, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	return nil
+}
@@ -5,117 +5,215 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"music-auth/graph/model"
+	"music-auth/internal/jobs"
+	"music-auth/internal/keys"
+	"music-auth/internal/mail"
 	"music-auth/internal/middleware"
+	"music-auth/internal/ratelimit"
+	netmail "net/mail"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthService struct {
 	db        *sql.DB
 	jwtSecret []byte
+	keyring   *keys.Keyring
+	mailer    mail.Mailer
+	appURL    string
+	limits    limiters
+	jobs      *jobs.Queue
 }
 
-func New(db *sql.DB, jwt_secret string) *AuthService {
-	return &AuthService{db: db, jwtSecret: []byte(jwt_secret)}
+// New wires the auth service. Access tokens are signed with keyring; the JWT
+// secret keys the HMACs of opaque tokens. appURL is the public base URL of
+// the frontend and is used to build links in outgoing email. limits holds
+// the counters behind login, sign-up and password reset throttling. Email
+// goes out through queue, so a mail server outage only delays it.
+func New(db *sql.DB, jwt_secret string, keyring *keys.Keyring, mailer mail.Mailer, appURL string, limits ratelimit.Store, queue *jobs.Queue) *AuthService {
+	a := &AuthService{
+		db:        db,
+		jwtSecret: []byte(jwt_secret),
+		keyring:   keyring,
+		mailer:    mailer,
+		appURL:    strings.TrimRight(appURL, "/"),
+		limits:    newLimiters(limits),
+		jobs:      queue,
+	}
+
+	queue.Register(kindSendMail, time.Minute, a.sendMail)
+
+	return a
+}
+
+const kindSendMail = "mail.send"
+
+// queueMail sends msg in the background, retrying if the mailer fails.
+func (a *AuthService) queueMail(ctx context.Context, msg mail.Message) error {
+	return a.jobs.Enqueue(ctx, nil, kindSendMail, msg)
+}
+
+func (a *AuthService) sendMail(ctx context.Context, job *jobs.Job) error {
+	var msg mail.Message
+	if err := job.Decode(&msg); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	return a.mailer.Send(ctx, msg)
 }
 
-func (a *AuthService) Register(username, email, password string) (string, *User, error) {
+// ErrRegistrationFailed is the answer to a sign-up whose email or username
+// is taken, without telling which, so sign-ups cannot probe for accounts.
+var ErrRegistrationFailed = errors.New("unable to register with this username and email")
+
+func (a *AuthService) Register(ctx context.Context, username, email, password string) (*TokenPair, *User, error) {
 
 	if username == "" || email == "" || password == "" {
-		return "", nil, fmt.Errorf("username, email and password, All fields are required")
+		return nil, nil, fmt.Errorf("username, email and password, All fields are required")
+	}
+
+	if err := throttled(ctx, a.limits.registerIP, ipKey(ctx, "register"), true); err != nil {
+		return nil, nil, err
 	}
 
 	hashedPassword, err := HashPassword(password)
 
 	if err != nil {
-		return "", nil, fmt.Errorf("unable to hash password %w", err)
+		return nil, nil, fmt.Errorf("unable to hash password %w", err)
 	}
 
-	query := `INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id, username, email`
+	query := `
+        INSERT INTO users (tenant_id, username, email, password)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, tenant_id, username, email
+    `
 
 	var user User
 
-	err = a.db.QueryRow(query, username, email, hashedPassword).Scan(&user.ID, &user.Username, &user.Email)
+	err = a.db.QueryRowContext(ctx, query, middleware.GetTenant(ctx), username, email, hashedPassword).Scan(
+		&user.ID, &user.TenantID, &user.Username, &user.Email,
+	)
 
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			switch pqErr.Constraint {
-			case "users_email_key":
-				fmt.Println("email")
-				return "", nil, errors.New("email already registered")
-			case "users_username_key":
-				fmt.Println("username")
-				return "", nil, errors.New("username already taken")
+			case "users_email_key", "users_username_key":
+				return nil, nil, ErrRegistrationFailed
 			}
 		}
-		return "", nil, fmt.Errorf("could not insert user: %w", err)
+		return nil, nil, fmt.Errorf("could not insert user: %w", err)
+	}
+
+	if err := a.sendVerification(ctx, user.ID, user.Email); err != nil {
+		slog.Error("send verification email", "user_id", user.ID, "error", err)
 	}
 
-	token, err := a.GenerateToken(&user)
+	tokens, err := a.startSession(ctx, &user)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to sign token: %w", err)
+		return nil, nil, err
 	}
 
-	return token, &user, nil
+	return tokens, &user, nil
 }
 
-func (a *AuthService) Login(email, password string) (string, error) {
+// Login checks the password and starts a session. For accounts with TOTP
+// enabled it instead returns an MFA challenge to be completed by VerifyMFA.
+// Failures are throttled per account and per client IP, and an unknown email
+// fails exactly like a wrong password.
+func (a *AuthService) Login(ctx context.Context, email, password string) (*TokenPair, *MFAChallenge, error) {
 	if email == "" || password == "" {
-		return "", errors.New("email and password are required")
+		return nil, nil, errors.New("email and password are required")
+	}
+
+	tenant := middleware.GetTenant(ctx)
+	account := accountKey("login", tenant, email)
+	ip := ipKey(ctx, "login")
+
+	if err := throttled(ctx, a.limits.loginIP, ip, false); err != nil {
+		return nil, nil, err
+	}
+	if err := throttled(ctx, a.limits.loginAccount, account, false); err != nil {
+		return nil, nil, err
 	}
 
 	var user User
 
-	query := `SELECT id, username, email, password FROM users WHERE email = $1`
-	err := a.db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", errors.New("user not found")
-		}
-		return "", err
+	query := `
+        SELECT id, tenant_id, username, email, COALESCE(password, '')
+        FROM users
+        WHERE tenant_id = $1 AND email = $2
+    `
+	err := a.db.QueryRowContext(ctx, query, tenant, email).Scan(
+		&user.ID, &user.TenantID, &user.Username, &user.Email, &user.Password,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, fmt.Errorf("internal server error")
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
-		return "", errors.New("invalid password")
+	// Unknown emails and social-only accounts have no hash to compare;
+	// spend the time of a check anyway.
+	if user.Password == "" {
+		burnPasswordCheck(password)
 	}
 
-	token, err := a.GenerateToken(&user)
+	if user.Password == "" || !CheckPasswordHash(password, user.Password) {
+		recordFailure(ctx, a.limits.loginAccount, account)
+		recordFailure(ctx, a.limits.loginIP, ip)
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	mfa, err := a.totpEnabled(ctx, user.ID)
 	if err != nil {
-		return "", err
+		return nil, nil, fmt.Errorf("internal server error")
+	}
+
+	// With 2FA the counter is only cleared once VerifyMFA succeeds, so wrong
+	// codes keep adding to it.
+	if mfa {
+		challenge, err := a.createMFAChallenge(ctx, user.ID)
+		return nil, challenge, err
 	}
 
-	return token, nil
+	// The IP counter is left alone: logging into one's own account must not
+	// buy more guesses at others.
+	a.resetLoginLimit(ctx, account)
+
+	tokens, err := a.startSession(ctx, &user)
+	return tokens, nil, err
 }
 
 func (a *AuthService) GetUserInfo(ctx context.Context) (*model.GetUserInfoResponse, error) {
-	claims, ok := middleware.GetUserFromContext(ctx)
-	fmt.Println(claims)
-	if !ok {
-		return &model.GetUserInfoResponse{
-			Success: false,
-			Message: "unauthorized",
-			User:    nil,
-		}, nil
-	}
+	claims := middleware.CurrentUser(ctx)
 
 	userID := claims.UserID
 
 	query := `
-    SELECT id, username, email, subscription_type, ending_subscription_date
+    SELECT id, username, email, email_verified, pending_email, subscription_type, ending_subscription_date,
+           EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = users.id AND t.confirmed_at IS NOT NULL),
+           role, ARRAY(SELECT permission FROM role_permissions rp WHERE rp.role = users.role ORDER BY permission)
     FROM users
-    WHERE id = $1
+    WHERE id = $1 AND tenant_id = $2
 `
 
-	var user model.GetUser
-	err := a.db.QueryRow(query, userID).Scan(
+	var (
+		user model.GetUser
+		role string
+	)
+	err := a.db.QueryRow(query, userID, claims.Tenant).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.EmailVerified,
+		&user.PendingEmail,
 		&user.AccountType,
 		&user.EndingDate,
+		&user.TotpEnabled,
+		&role,
+		pq.Array(&user.Permissions),
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -127,27 +225,32 @@ func (a *AuthService) GetUserInfo(ctx context.Context) (*model.GetUserInfoRespon
 	return &model.GetUserInfoResponse{
 		Success: true,
 		User: &model.GetUser{
-			ID:          user.ID,
-			Username:    user.Username,
-			Email:       user.Email,
-			AccountType: user.AccountType,
-			EndingDate:  user.EndingDate,
+			ID:            user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			PendingEmail:  user.PendingEmail,
+			AccountType:   user.AccountType,
+			EndingDate:    user.EndingDate,
+			TotpEnabled:   user.TotpEnabled,
+			Role:          model.Role(strings.ToUpper(role)),
+			Permissions:   user.Permissions,
 		},
 	}, nil
 }
 
 func (a *AuthService) UpdatePassword(ctx context.Context, oldPassword, newPassword string) (*model.BasicResponse, error) {
-	claims, ok := middleware.GetUserFromContext(ctx)
+	claims := middleware.CurrentUser(ctx)
 
-	if !ok {
-		return nil, fmt.Errorf("Unauthorized")
+	if claims.Actor != nil {
+		return nil, ErrImpersonating
 	}
 
 	userID := claims.UserID
 
-	query := `SELECT password FROM users WHERE id = $1`
+	query := `SELECT COALESCE(password, '') FROM users WHERE id = $1 AND tenant_id = $2`
 
-	res := a.db.QueryRow(query, userID)
+	res := a.db.QueryRow(query, userID, claims.Tenant)
 
 	var password string
 	err := res.Scan(&password)
@@ -160,7 +263,7 @@ func (a *AuthService) UpdatePassword(ctx context.Context, oldPassword, newPasswo
 		return nil, fmt.Errorf("internal server error")
 	}
 
-	ok = CheckPasswordHash(oldPassword, password)
+	ok := CheckPasswordHash(oldPassword, password)
 
 	if !ok {
 		return nil, fmt.Errorf("passwords donot match")
@@ -172,54 +275,79 @@ func (a *AuthService) UpdatePassword(ctx context.Context, oldPassword, newPasswo
 		return nil, fmt.Errorf("unable to update password, try again later")
 	}
 
-	query = `UPDATE users SET password = $1 WHERE id = $2`
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to update password, try again later")
+	}
+	defer tx.Rollback()
+
+	query = `UPDATE users SET password = $1, updated_at = now() WHERE id = $2 AND tenant_id = $3`
 
-	_, err = a.db.Exec(query, userID, hashedPassword)
+	_, err = tx.ExecContext(ctx, query, hashedPassword, userID, claims.Tenant)
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to update password, try again later")
 	}
 
+	// Anyone holding an old session may have learned the old password.
+	if err := a.revokeSessions(ctx, tx, userID, nil, &claims.SessionID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to update password, try again later")
+	}
+
 	return &model.BasicResponse{
 		Success: true,
 		Message: "Password updated",
 	}, nil
 }
 
+// UpdateEmail stages newEmail as the user's pending address and mails a
+// verification link to it. The address only takes effect once verified.
 func (a *AuthService) UpdateEmail(ctx context.Context, newEmail string) error {
-	claims, ok := middleware.GetUserFromContext(ctx)
+	claims := middleware.CurrentUser(ctx)
 
-	if !ok {
-		return fmt.Errorf("Unauthorized")
+	if claims.Actor != nil {
+		return ErrImpersonating
 	}
 
 	userID := claims.UserID
 
-	query := `UPDATE users SET email = $1 WHERE id = $2`
+	addr, err := netmail.ParseAddress(newEmail)
+	if err != nil || addr.Address != newEmail {
+		return fmt.Errorf("invalid email address")
+	}
 
-	_, err := a.db.Exec(query, newEmail, userID)
+	// Whether another account already has the address is only checked once
+	// the link is followed, so this cannot be used to probe for accounts.
+	query := `UPDATE users SET pending_email = $1, updated_at = now() WHERE id = $2 AND tenant_id = $3`
+
+	_, err = a.db.ExecContext(ctx, query, newEmail, userID, claims.Tenant)
 
 	if err != nil {
 		return fmt.Errorf("email update unsuccessfull, try again later")
 	}
 
+	if err := a.sendVerification(ctx, userID, newEmail); err != nil {
+		slog.Error("send verification email", "user_id", userID, "error", err)
+		return fmt.Errorf("unable to send verification email, try again later")
+	}
+
 	return nil
 
 }
 
 func (a *AuthService) UpdateUsername(ctx context.Context, newUsername string) error {
 
-	claims, ok := middleware.GetUserFromContext(ctx)
-
-	if !ok {
-		return fmt.Errorf("Unauthorized")
-	}
+	claims := middleware.CurrentUser(ctx)
 
 	userID := claims.UserID
 
-	query := `UPDATE users SET username = $1 WHERE id = $2`
+	query := `UPDATE users SET username = $1 WHERE id = $2 AND tenant_id = $3`
 
-	result, err := a.db.Exec(query, newUsername, userID)
+	result, err := a.db.Exec(query, newUsername, userID, claims.Tenant)
 	if err != nil {
 		return fmt.Errorf("username update unsuccessful, try again later")
 	}
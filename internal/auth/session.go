@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"music-auth/graph/model"
+	"music-auth/internal/middleware"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written so that
+// AuthMiddleware does not turn every request into an UPDATE.
+const sessionTouchInterval = time.Minute
+
+// startSession records a new server-side session for the device making the
+// request and issues the first token pair for it.
+func (a *AuthService) startSession(ctx context.Context, user *User) (*TokenPair, error) {
+	return a.createSession(ctx, user, uuid.NullUUID{})
+}
+
+// createSession is startSession for either the user themselves or, with
+// impersonator set, an admin acting as them.
+func (a *AuthService) createSession(ctx context.Context, user *User, impersonator uuid.NullUUID) (*TokenPair, error) {
+	var userAgent, ip sql.NullString
+	if r := middleware.GetRequest(ctx); r != nil {
+		userAgent = sql.NullString{String: r.UserAgent(), Valid: r.UserAgent() != ""}
+		ip = sql.NullString{String: middleware.ClientIP(r), Valid: true}
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO sessions (user_id, user_agent, ip_address, expires_at, impersonator_id)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `
+
+	var sessionID uuid.UUID
+	err = tx.QueryRowContext(ctx, query, user.ID, userAgent, ip, time.Now().Add(RefreshTokenTTL), impersonator).Scan(&sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	tokens, err := a.issueTokens(ctx, tx, user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	return tokens, nil
+}
+
+// IsSessionActive implements middleware.SessionStore.
+func (a *AuthService) IsSessionActive(ctx context.Context, userID, sessionID uuid.UUID) (bool, error) {
+	query := `
+        SELECT last_seen_at
+        FROM sessions
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()
+    `
+
+	var lastSeen time.Time
+	err := a.db.QueryRowContext(ctx, query, sessionID, userID).Scan(&lastSeen)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if time.Since(lastSeen) > sessionTouchInterval {
+		_, err = a.db.ExecContext(ctx, `UPDATE sessions SET last_seen_at = now() WHERE id = $1`, sessionID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (a *AuthService) ListSessions(ctx context.Context) ([]*model.Session, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `
+        SELECT id, user_agent, ip_address, created_at, last_seen_at
+        FROM sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+        ORDER BY last_seen_at DESC
+    `
+
+	rows, err := a.db.QueryContext(ctx, query, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("db error: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*model.Session{}
+	for rows.Next() {
+		var (
+			id                  uuid.UUID
+			userAgent, ip       sql.NullString
+			createdAt, lastSeen time.Time
+		)
+		if err := rows.Scan(&id, &userAgent, &ip, &createdAt, &lastSeen); err != nil {
+			return nil, fmt.Errorf("db error: %w", err)
+		}
+
+		session := &model.Session{
+			ID:         id.String(),
+			CreatedAt:  createdAt.Format(time.RFC3339),
+			LastSeenAt: lastSeen.Format(time.RFC3339),
+			Current:    id == claims.SessionID,
+		}
+		if userAgent.Valid {
+			session.UserAgent = &userAgent.String
+		}
+		if ip.Valid {
+			session.IPAddress = &ip.String
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Logout revokes the session the caller is currently using.
+func (a *AuthService) Logout(ctx context.Context) error {
+	claims := middleware.CurrentUser(ctx)
+
+	return a.revokeSessions(ctx, a.db, claims.UserID, &claims.SessionID, nil)
+}
+
+// LogoutAllDevices revokes every session belonging to the caller, including
+// the current one.
+func (a *AuthService) LogoutAllDevices(ctx context.Context) error {
+	claims := middleware.CurrentUser(ctx)
+
+	return a.revokeSessions(ctx, a.db, claims.UserID, nil, nil)
+}
+
+// revokeSessions revokes either a single session (only) or every session of
+// the user except one (keep). Refresh tokens of revoked sessions are revoked
+// too so they cannot be rotated back to life. Revoking every session also
+// revokes the refresh tokens third-party clients got from the OpenID
+// provider, so logging out everywhere covers them as well.
+func (a *AuthService) revokeSessions(ctx context.Context, q execer, userID uuid.UUID, only, keep *uuid.UUID) error {
+	var onlyID, keepID uuid.NullUUID
+	if only != nil {
+		onlyID = uuid.NullUUID{UUID: *only, Valid: true}
+	}
+	if keep != nil {
+		keepID = uuid.NullUUID{UUID: *keep, Valid: true}
+	}
+
+	query := `
+        WITH oauth AS (
+            DELETE FROM oauth_refresh_tokens WHERE user_id = $1 AND $2::uuid IS NULL
+        ),
+        revoked AS (
+            UPDATE sessions SET revoked_at = now()
+            WHERE user_id = $1
+              AND revoked_at IS NULL
+              AND ($2::uuid IS NULL OR id = $2)
+              AND ($3::uuid IS NULL OR id <> $3)
+            RETURNING id
+        )
+        UPDATE refresh_tokens SET revoked_at = now()
+        WHERE family_id IN (SELECT id FROM revoked) AND revoked_at IS NULL
+    `
+
+	_, err := q.ExecContext(ctx, query, userID, onlyID, keepID)
+	if err != nil {
+		return fmt.Errorf("unable to revoke sessions, try again later")
+	}
+
+	return nil
+}
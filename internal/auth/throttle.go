@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"music-auth/internal/middleware"
+	"music-auth/internal/ratelimit"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCredentials is the only answer to a failed login, whether or not
+// the email belongs to an account.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+var (
+	// A single account: a short grace for typos, then doubling delays and a
+	// lockout that slows online guessing to a crawl.
+	accountLoginPolicy = ratelimit.Policy{
+		Free:         5,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 10,
+		Lockout:      15 * time.Minute,
+		Window:       time.Hour,
+	}
+
+	// A single client IP across all accounts, to stop spraying one password
+	// over many emails. Looser, since users can share an address.
+	ipLoginPolicy = ratelimit.Policy{
+		Free:         30,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 100,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+
+	// Sign-ups and password reset requests are counted whether or not they
+	// succeed.
+	ipRegisterPolicy = ratelimit.Policy{
+		Free:         10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		LockoutAfter: 20,
+		Lockout:      24 * time.Hour,
+		Window:       24 * time.Hour,
+	}
+
+	ipResetPolicy = ratelimit.Policy{
+		Free:         10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		LockoutAfter: 30,
+		Lockout:      24 * time.Hour,
+		Window:       24 * time.Hour,
+	}
+)
+
+type limiters struct {
+	loginAccount *ratelimit.Limiter
+	loginIP      *ratelimit.Limiter
+	registerIP   *ratelimit.Limiter
+	resetIP      *ratelimit.Limiter
+}
+
+func newLimiters(store ratelimit.Store) limiters {
+	return limiters{
+		loginAccount: ratelimit.New(store, accountLoginPolicy),
+		loginIP:      ratelimit.New(store, ipLoginPolicy),
+		registerIP:   ratelimit.New(store, ipRegisterPolicy),
+		resetIP:      ratelimit.New(store, ipResetPolicy),
+	}
+}
+
+// accountKey identifies the account an attempt is aimed at by what the
+// caller typed, so unknown emails are throttled exactly like real ones.
+func accountKey(flow, tenant, email string) string {
+	return flow + ":account:" + tenant + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey returns the limiter key for the client's IP, or "" outside an HTTP
+// request.
+func ipKey(ctx context.Context, flow string) string {
+	r := middleware.GetRequest(ctx)
+	if r == nil {
+		return ""
+	}
+	return flow + ":ip:" + middleware.ClientIP(r)
+}
+
+// throttled reports whether the caller must wait. Rate limit errors are
+// passed through; store failures become an internal error.
+func throttled(ctx context.Context, l *ratelimit.Limiter, key string, hit bool) error {
+	if key == "" {
+		return nil
+	}
+
+	var err error
+	if hit {
+		err = l.Hit(ctx, key)
+	} else {
+		err = l.Allow(ctx, key)
+	}
+
+	var limited *ratelimit.Error
+	if err == nil || errors.As(err, &limited) {
+		return err
+	}
+
+	slog.Error("rate limiter", "key", key, "error", err)
+	return fmt.Errorf("internal server error")
+}
+
+// recordFailure counts a failed attempt. Errors are only logged: the
+// caller already has an answer for the user.
+func recordFailure(ctx context.Context, l *ratelimit.Limiter, key string) {
+	if key == "" {
+		return
+	}
+
+	var limited *ratelimit.Error
+	if err := l.Hit(ctx, key); err != nil && !errors.As(err, &limited) {
+		slog.Error("rate limiter", "key", key, "error", err)
+	}
+}
+
+func (a *AuthService) resetLoginLimit(ctx context.Context, account string) {
+	if err := a.limits.loginAccount.Reset(ctx, account); err != nil {
+		slog.Error("reset login limit", "key", account, "error", err)
+	}
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// burnPasswordCheck spends as long as a real password check, so a login for
+// an unknown email is not answered measurably faster.
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("not the password of any account")
+	})
+	CheckPasswordHash(password, dummyHash)
+}
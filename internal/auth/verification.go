@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"music-auth/internal/mail"
+	"music-auth/internal/middleware"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	verificationTokenTTL       = 48 * time.Hour
+	verificationResendInterval = time.Minute
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+
+// ErrEmailUnavailable is the answer to confirming a new address that another
+// account took in the meantime. Like ErrRegistrationFailed it doesn't say so.
+var ErrEmailUnavailable = errors.New("unable to change to this email address")
+
+// sendVerification stores a new single-use token for (userID, email) and mails
+// the confirmation link to that address.
+func (a *AuthService) sendVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	token, err := a.newSignedToken(purposeEmailVerification)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	query := `
+        INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `
+
+	_, err = a.db.ExecContext(ctx, query, userID, email, hashToken(token), time.Now().Add(verificationTokenTTL))
+	if err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+
+	link := a.appURL + "/verify-email?token=" + url.QueryEscape(token)
+
+	return a.queueMail(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Text: "Confirm this address for your music-store account by opening the link below:\n\n" +
+			link + "\n\nThe link expires in 48 hours. If you did not request this, ignore this email.\n",
+	})
+}
+
+// VerifyEmail consumes a verification token. If it was issued for the user's
+// pending address, that address replaces the current one.
+func (a *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	if !a.verifySignedToken(purposeEmailVerification, token) {
+		return ErrInvalidVerificationToken
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("internal server error")
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT t.id, t.user_id, t.email, t.expires_at, t.used_at, u.email, u.pending_email
+        FROM email_verification_tokens t
+        JOIN users u ON u.id = t.user_id
+        WHERE t.token_hash = $1 AND u.tenant_id = $2
+        FOR UPDATE OF t, u
+    `
+
+	var (
+		tokenID      uuid.UUID
+		userID       uuid.UUID
+		tokenEmail   string
+		expiresAt    time.Time
+		usedAt       sql.NullTime
+		currentEmail string
+		pendingEmail sql.NullString
+	)
+
+	err = tx.QueryRowContext(ctx, query, hashToken(token), middleware.GetTenant(ctx)).Scan(
+		&tokenID, &userID, &tokenEmail, &expiresAt, &usedAt, &currentEmail, &pendingEmail,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidVerificationToken
+		}
+		return fmt.Errorf("internal server error")
+	}
+
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return ErrInvalidVerificationToken
+	}
+
+	switch {
+	case tokenEmail == currentEmail:
+		_, err = tx.ExecContext(ctx, `UPDATE users SET email_verified = true, updated_at = now() WHERE id = $1`, userID)
+
+	case pendingEmail.Valid && tokenEmail == pendingEmail.String:
+		query = `
+            UPDATE users
+            SET email = pending_email, pending_email = NULL, email_verified = true, updated_at = now()
+            WHERE id = $1
+        `
+		_, err = tx.ExecContext(ctx, query, userID)
+
+	default:
+		// The address was changed again after this link was sent.
+		return ErrInvalidVerificationToken
+	}
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "users_email_key" {
+			return ErrEmailUnavailable
+		}
+		return fmt.Errorf("email verification unsuccessful, try again later")
+	}
+
+	// Every other outstanding link for this address is now pointless.
+	query = `UPDATE email_verification_tokens SET used_at = now() WHERE user_id = $1 AND email = $2 AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID, tokenEmail); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("internal server error")
+	}
+
+	return nil
+}
+
+// ResendVerification mails a fresh link for the caller's pending address, or
+// for their current address if it has not been verified yet.
+func (a *AuthService) ResendVerification(ctx context.Context) error {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `
+        SELECT u.email, u.email_verified, u.pending_email,
+               (SELECT max(created_at) FROM email_verification_tokens WHERE user_id = u.id)
+        FROM users u
+        WHERE u.id = $1 AND u.tenant_id = $2
+    `
+
+	var (
+		email        string
+		verified     bool
+		pendingEmail sql.NullString
+		lastSent     sql.NullTime
+	)
+
+	err := a.db.QueryRowContext(ctx, query, claims.UserID, claims.Tenant).Scan(&email, &verified, &pendingEmail, &lastSent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("db error: %w", err)
+	}
+
+	target := email
+	if pendingEmail.Valid {
+		target = pendingEmail.String
+	} else if verified {
+		return errors.New("email already verified")
+	}
+
+	if lastSent.Valid && time.Since(lastSent.Time) < verificationResendInterval {
+		return errors.New("verification email sent recently, try again in a minute")
+	}
+
+	if err := a.sendVerification(ctx, claims.UserID, target); err != nil {
+		slog.Error("send verification email", "user_id", claims.UserID, "error", err)
+		return fmt.Errorf("unable to send verification email, try again later")
+	}
+
+	return nil
+}
@@ -5,9 +5,26 @@ import (
 	"github.com/google/uuid"
 )
 
+// Claims carries a unique token id in RegisteredClaims.ID (jti) and the
+// server-side session it belongs to, which AuthMiddleware checks on every
+// request so revoked sessions stop working before the token expires. Tenant
+// is the storefront the user belongs to; tokens are only accepted on requests
+// resolved to the same tenant. Role and Permissions are read from the
+// database when the token is issued, so a change applies from the next
+// refresh.
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	Tenant string    `json:"tenant"`
+	UserID      uuid.UUID `json:"user_id"`
+	Email       string    `json:"email"`
+	Tenant      string    `json:"tenant"`
+	Role        string    `json:"role"`
+	Permissions []string  `json:"perms,omitempty"`
+	SessionID   uuid.UUID `json:"sid"`
+	Actor       *Actor    `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
+
+// Actor is set while an admin impersonates the user (the RFC 8693 "act"
+// claim): Subject is the admin.
+type Actor struct {
+	Subject uuid.UUID `json:"sub"`
+}
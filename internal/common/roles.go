@@ -0,0 +1,38 @@
+package common
+
+const (
+	RoleListener      = "listener"
+	RoleArtist        = "artist"
+	RoleLabelAdmin    = "label_admin"
+	RolePlatformAdmin = "platform_admin"
+)
+
+// roleRank orders the roles: each one can do everything the ones below it
+// can. Tokens without a role rank below listener.
+var roleRank = map[string]int{
+	RoleListener:      1,
+	RoleArtist:        2,
+	RoleLabelAdmin:    3,
+	RolePlatformAdmin: 4,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether the caller's role is role or above it.
+func (c *Claims) HasRole(role string) bool {
+	need, ok := roleRank[role]
+	return ok && roleRank[c.Role] >= need
+}
+
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package idp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log/slog"
+	"music-auth/internal/common"
+	"music-auth/internal/middleware"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type authorizeRequest struct {
+	client              *Client
+	redirectURI         string
+	scopes              []string
+	state               string
+	nonce               string
+	codeChallenge       string
+	codeChallengeMethod string
+	prompt              string
+	params              url.Values
+}
+
+// authorizeError is either shown to the user (the client or redirect URI
+// cannot be trusted) or sent back to the client, per RFC 6749 4.1.2.1.
+type authorizeError struct {
+	code        string
+	description string
+	redirect    bool
+}
+
+func (s *Server) parseAuthorize(ctx context.Context, params url.Values) (*authorizeRequest, *authorizeError) {
+	req := &authorizeRequest{
+		redirectURI:         params.Get("redirect_uri"),
+		state:               params.Get("state"),
+		nonce:               params.Get("nonce"),
+		codeChallenge:       params.Get("code_challenge"),
+		codeChallengeMethod: params.Get("code_challenge_method"),
+		prompt:              params.Get("prompt"),
+		params:              params,
+	}
+
+	client, err := s.getClient(ctx, params.Get("client_id"))
+	if err != nil {
+		return nil, &authorizeError{code: "invalid_client", description: "unknown client"}
+	}
+	req.client = client
+
+	// Redirect URIs must match a registered one exactly; no prefix or
+	// wildcard matching.
+	if !hasScope(client.RedirectURIs, req.redirectURI) {
+		return nil, &authorizeError{code: "invalid_request", description: "redirect_uri is not registered for this client"}
+	}
+
+	if params.Get("response_type") != "code" {
+		return req, &authorizeError{code: "unsupported_response_type", description: "only the code flow is supported", redirect: true}
+	}
+
+	req.scopes = strings.Fields(params.Get("scope"))
+	if !hasScope(req.scopes, "openid") {
+		return req, &authorizeError{code: "invalid_scope", description: "the openid scope is required", redirect: true}
+	}
+	if !containsAll(client.AllowedScopes, req.scopes) {
+		return req, &authorizeError{code: "invalid_scope", description: "scope not allowed for this client", redirect: true}
+	}
+
+	if req.codeChallenge != "" && req.codeChallengeMethod != "S256" {
+		return req, &authorizeError{code: "invalid_request", description: "code_challenge_method must be S256", redirect: true}
+	}
+	if req.codeChallenge == "" && client.Public() {
+		return req, &authorizeError{code: "invalid_request", description: "public clients must use PKCE", redirect: true}
+	}
+
+	switch req.prompt {
+	case "", "none", "consent":
+	default:
+		return req, &authorizeError{code: "invalid_request", description: "unsupported prompt value", redirect: true}
+	}
+
+	return req, nil
+}
+
+// AuthorizeHandler serves /oauth2/authorize. GET starts the flow and POST
+// receives the answer from the consent page. It relies on AuthMiddleware to
+// identify the logged-in user from the session cookie.
+func (s *Server) AuthorizeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.authorize(w, r)
+		case http.MethodPost:
+			s.consent(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	req, aerr := s.parseAuthorize(r.Context(), r.URL.Query())
+	if aerr != nil {
+		s.authorizeFail(w, r, req, aerr)
+		return
+	}
+
+	// AuthMiddleware only accepts sessions of the request's tenant, which is
+	// also the client's, so users never cross storefronts here.
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		if req.prompt == "none" {
+			s.authorizeFail(w, r, req, &authorizeError{code: "login_required", redirect: true})
+			return
+		}
+		q := url.Values{"continue": {s.issuer + "/oauth2/authorize?" + req.params.Encode()}}
+		http.Redirect(w, r, s.loginURL+"?"+q.Encode(), http.StatusFound)
+		return
+	}
+
+	if !req.client.FirstParty {
+		granted, err := s.hasConsent(r.Context(), claims, req)
+		if err != nil {
+			slog.Error("oauth consent lookup", "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !granted || req.prompt == "consent" {
+			if req.prompt == "none" {
+				s.authorizeFail(w, r, req, &authorizeError{code: "consent_required", redirect: true})
+				return
+			}
+			s.renderConsent(w, claims, req)
+			return
+		}
+	}
+
+	s.issueCode(w, r, claims, req)
+}
+
+func (s *Server) consent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	params := url.Values{}
+	for k, v := range r.PostForm {
+		if k != "csrf" && k != "decision" {
+			params[k] = v
+		}
+	}
+
+	req, aerr := s.parseAuthorize(r.Context(), params)
+	if aerr != nil {
+		s.authorizeFail(w, r, req, aerr)
+		return
+	}
+
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok || !hmac.Equal([]byte(r.PostForm.Get("csrf")), []byte(s.consentMAC(claims, params))) {
+		http.Error(w, "consent expired, please try again", http.StatusForbidden)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		s.authorizeFail(w, r, req, &authorizeError{code: "access_denied", description: "the user denied the request", redirect: true})
+		return
+	}
+
+	query := `
+        INSERT INTO oauth_consents (user_id, client_id, scopes)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, client_id) DO UPDATE
+        SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_consents.scopes || EXCLUDED.scopes)),
+            granted_at = now()
+    `
+
+	if _, err := s.db.ExecContext(r.Context(), query, claims.UserID, req.client.ID, pq.Array(req.scopes)); err != nil {
+		slog.Error("oauth consent save", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.issueCode(w, r, claims, req)
+}
+
+func (s *Server) hasConsent(ctx context.Context, claims *common.Claims, req *authorizeRequest) (bool, error) {
+	var scopes []string
+	query := `SELECT scopes FROM oauth_consents WHERE user_id = $1 AND client_id = $2`
+
+	err := s.db.QueryRowContext(ctx, query, claims.UserID, req.client.ID).Scan(pq.Array(&scopes))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return containsAll(scopes, req.scopes), nil
+}
+
+func (s *Server) issueCode(w http.ResponseWriter, r *http.Request, claims *common.Claims, req *authorizeRequest) {
+	code, err := randomToken()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// auth_time is when the user actually logged in, i.e. when the session
+	// behind the cookie started, not when this code was issued.
+	query := `
+        INSERT INTO oauth_authorization_codes
+            (code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, code_challenge_method, auth_time, expires_at)
+        SELECT $1, $2, $3, $4, $5, $6, $7, $8, s.created_at, $9
+        FROM sessions s
+        WHERE s.id = $10 AND s.user_id = $3
+    `
+
+	res, err := s.db.ExecContext(r.Context(), query,
+		hashToken(code), req.client.ID, claims.UserID, req.redirectURI, pq.Array(req.scopes),
+		nullString(req.nonce), nullString(req.codeChallenge), nullString(req.codeChallengeMethod),
+		time.Now().Add(authorizationCodeTTL), claims.SessionID,
+	)
+	if err != nil {
+		slog.Error("oauth code issue", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "session expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	q := url.Values{"code": {code}}
+	if req.state != "" {
+		q.Set("state", req.state)
+	}
+	http.Redirect(w, r, withQuery(req.redirectURI, q), http.StatusFound)
+}
+
+func (s *Server) authorizeFail(w http.ResponseWriter, r *http.Request, req *authorizeRequest, aerr *authorizeError) {
+	if !aerr.redirect {
+		http.Error(w, aerr.description, http.StatusBadRequest)
+		return
+	}
+
+	q := url.Values{"error": {aerr.code}}
+	if aerr.description != "" {
+		q.Set("error_description", aerr.description)
+	}
+	if req.state != "" {
+		q.Set("state", req.state)
+	}
+	http.Redirect(w, r, withQuery(req.redirectURI, q), http.StatusFound)
+}
+
+// consentMAC binds the consent form to the user's session and the exact
+// request parameters, so another site cannot post a decision on their behalf.
+func (s *Server) consentMAC(claims *common.Claims, params url.Values) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte("oauth-consent\x00"))
+	h.Write([]byte(claims.SessionID.String()))
+	h.Write([]byte{0})
+	h.Write([]byte(params.Encode()))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+var scopeDescriptions = map[string]string{
+	"openid":         "Confirm who you are",
+	"profile":        "See your username",
+	"email":          "See your email address",
+	"offline_access": "Stay signed in when you are not using it",
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client}}</title></head>
+<body>
+<h1>{{.Client}} wants to access your account</h1>
+<p>Signed in as {{.Email}}.</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<form method="post" action="/oauth2/authorize">
+{{range .Fields}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{end}}<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+type hiddenField struct {
+	Name  string
+	Value string
+}
+
+func (s *Server) renderConsent(w http.ResponseWriter, claims *common.Claims, req *authorizeRequest) {
+	var scopes []string
+	for _, scope := range req.scopes {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+
+	names := make([]string, 0, len(req.params))
+	for name := range req.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []hiddenField
+	for _, name := range names {
+		for _, value := range req.params[name] {
+			fields = append(fields, hiddenField{Name: name, Value: value})
+		}
+	}
+	fields = append(fields, hiddenField{Name: "csrf", Value: s.consentMAC(claims, req.params)})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+
+	consentPage.Execute(w, map[string]any{
+		"Client": req.client.Name,
+		"Email":  claims.Email,
+		"Scopes": scopes,
+		"Fields": fields,
+	})
+}
+
+func withQuery(uri string, q url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + q.Encode()
+	}
+	return uri + "?" + q.Encode()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,177 @@
+package idp
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"music-auth/internal/middleware"
+	"net/http"
+	"net/url"
+
+	"github.com/lib/pq"
+)
+
+type Client struct {
+	ID            string
+	TenantID      string
+	SecretHash    sql.NullString
+	Name          string
+	RedirectURIs  []string
+	AllowedScopes []string
+	FirstParty    bool
+}
+
+// Public clients (SPAs, mobile apps) have no secret and must use PKCE.
+func (c *Client) Public() bool {
+	return !c.SecretHash.Valid
+}
+
+type ClientSpec struct {
+	Tenant       string
+	Name         string
+	RedirectURIs []string
+	Scopes       []string
+	Public       bool
+	FirstParty   bool
+}
+
+// CreateClient registers a relying party and returns its id and, for
+// confidential clients, the secret. The secret is not stored in clear and
+// cannot be shown again.
+func CreateClient(ctx context.Context, db *sql.DB, spec ClientSpec) (string, string, error) {
+	if spec.Tenant == "" || spec.Name == "" || len(spec.RedirectURIs) == 0 {
+		return "", "", errors.New("tenant, name and at least one redirect URI are required")
+	}
+
+	for _, uri := range spec.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return "", "", fmt.Errorf("invalid redirect URI %q", uri)
+		}
+	}
+
+	scopes := spec.Scopes
+	if len(scopes) == 0 {
+		scopes = supportedScopes
+	}
+	for _, scope := range scopes {
+		if !hasScope(supportedScopes, scope) {
+			return "", "", fmt.Errorf("unsupported scope %q", scope)
+		}
+	}
+
+	clientID, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	clientID = clientID[:22]
+
+	var secret string
+	var secretHash sql.NullString
+	if !spec.Public {
+		if secret, err = randomToken(); err != nil {
+			return "", "", err
+		}
+		secretHash = sql.NullString{String: hashToken(secret), Valid: true}
+	}
+
+	query := `
+        INSERT INTO oauth_clients (client_id, tenant_id, client_secret_hash, name, redirect_uris, allowed_scopes, first_party)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+
+	_, err = db.ExecContext(ctx, query, clientID, spec.Tenant, secretHash, spec.Name,
+		pq.Array(spec.RedirectURIs), pq.Array(scopes), spec.FirstParty)
+	if err != nil {
+		return "", "", fmt.Errorf("could not create client: %w", err)
+	}
+
+	return clientID, secret, nil
+}
+
+// getClient only finds clients of the tenant the request was resolved to.
+func (s *Server) getClient(ctx context.Context, clientID string) (*Client, error) {
+	query := `
+        SELECT client_id, tenant_id, client_secret_hash, name, redirect_uris, allowed_scopes, first_party
+        FROM oauth_clients
+        WHERE client_id = $1 AND tenant_id = $2
+    `
+
+	var c Client
+	err := s.db.QueryRowContext(ctx, query, clientID, middleware.GetTenant(ctx)).Scan(
+		&c.ID, &c.TenantID, &c.SecretHash, &c.Name, pq.Array(&c.RedirectURIs), pq.Array(&c.AllowedScopes), &c.FirstParty,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// authenticateClient implements client_secret_basic, client_secret_post and
+// (for public clients) none.
+func (s *Server) authenticateClient(r *http.Request) (*Client, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return nil, errors.New("malformed client credentials")
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, errors.New("malformed client credentials")
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, errors.New("client authentication required")
+	}
+
+	client, err := s.getClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errors.New("unknown client")
+	}
+
+	if client.Public() {
+		if secret != "" {
+			return nil, errors.New("public clients must not send a secret")
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return nil, errors.New("invalid client credentials")
+	}
+
+	return client, nil
+}
+
+// ListClients returns every registered client, for the `clients list` command.
+func ListClients(ctx context.Context, db *sql.DB) ([]*Client, error) {
+	query := `
+        SELECT client_id, tenant_id, client_secret_hash, name, redirect_uris, allowed_scopes, first_party
+        FROM oauth_clients
+        ORDER BY tenant_id, created_at
+    `
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*Client
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.TenantID, &c.SecretHash, &c.Name, pq.Array(&c.RedirectURIs), pq.Array(&c.AllowedScopes), &c.FirstParty); err != nil {
+			return nil, err
+		}
+		clients = append(clients, &c)
+	}
+
+	return clients, rows.Err()
+}
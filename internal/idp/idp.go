@@ -0,0 +1,138 @@
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"music-auth/internal/keys"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	authorizationCodeTTL = time.Minute
+	accessTokenTTL       = 15 * time.Minute
+	idTokenTTL           = 15 * time.Minute
+	refreshTokenTTL      = 30 * 24 * time.Hour
+)
+
+var supportedScopes = []string{"openid", "profile", "email", "offline_access"}
+
+// Server makes this service an OAuth 2.0 / OpenID Connect authorization
+// server for the other music apps. Users authenticate with their normal
+// session cookie; clients receive ID tokens and RFC 9068 access tokens signed
+// by the same keyring that backs /.well-known/jwks.json.
+type Server struct {
+	db       *sql.DB
+	keyring  *keys.Keyring
+	issuer   string
+	loginURL string
+	secret   []byte
+}
+
+// New needs an asymmetric keyring: relying parties must be able to verify
+// our tokens from the JWKS without sharing a secret.
+func New(db *sql.DB, keyring *keys.Keyring, issuer, appURL, secret string) (*Server, error) {
+	if keyring.Algorithm() == keys.AlgHS256 {
+		return nil, fmt.Errorf("the OIDC provider requires JWT_SIGNING_ALG=%s or %s", keys.AlgRS256, keys.AlgEdDSA)
+	}
+
+	return &Server{
+		db:       db,
+		keyring:  keyring,
+		issuer:   strings.TrimRight(issuer, "/"),
+		loginURL: strings.TrimRight(appURL, "/") + "/login",
+		secret:   []byte(secret),
+	}, nil
+}
+
+// DiscoveryHandler serves GET /.well-known/openid-configuration.
+func (s *Server) DiscoveryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                s.issuer,
+			"authorization_endpoint":                s.issuer + "/oauth2/authorize",
+			"token_endpoint":                        s.issuer + "/oauth2/token",
+			"userinfo_endpoint":                     s.issuer + "/oauth2/userinfo",
+			"jwks_uri":                              s.issuer + "/.well-known/jwks.json",
+			"response_types_supported":              []string{"code"},
+			"response_modes_supported":              []string{"query"},
+			"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{s.keyring.Algorithm()},
+			"scopes_supported":                      supportedScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "preferred_username"},
+		})
+	})
+}
+
+type userClaims struct {
+	Username      string
+	Email         string
+	EmailVerified bool
+}
+
+// loadUser only finds active users, so suspended and deleted accounts can
+// neither be issued tokens nor read their profile.
+func (s *Server) loadUser(ctx context.Context, tenant, userID string) (*userClaims, error) {
+	var u userClaims
+	query := `
+        SELECT username, email, email_verified FROM users
+        WHERE id = $1 AND tenant_id = $2 AND suspended_at IS NULL AND deleted_at IS NULL
+    `
+	if err := s.db.QueryRowContext(ctx, query, userID, tenant).Scan(&u.Username, &u.Email, &u.EmailVerified); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// oauthError writes an RFC 6749 section 5.2 error response.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		if !hasScope(have, w) {
+			return false
+		}
+	}
+	return true
+}
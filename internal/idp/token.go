@@ -0,0 +1,318 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// AccessClaims are the claims of an RFC 9068 JWT access token.
+type AccessClaims struct {
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
+	Tenant   string `json:"tenant"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type idClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// grant is what a code or refresh token entitles the client to.
+type grant struct {
+	userID   uuid.UUID
+	scopes   []string
+	nonce    string
+	authTime time.Time
+}
+
+// TokenHandler serves POST /oauth2/token for the authorization_code and
+// refresh_token grants.
+func (s *Server) TokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+			return
+		}
+
+		client, err := s.authenticateClient(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+			oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+			return
+		}
+
+		var g *grant
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			g, err = s.redeemCode(r, client)
+		case "refresh_token":
+			g, err = s.redeemRefreshToken(r, client)
+		default:
+			oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+			return
+		}
+
+		if err != nil {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+
+		resp, err := s.issueTokens(r, client, g)
+		if err == sql.ErrNoRows {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "user is no longer active")
+			return
+		}
+		if err != nil {
+			slog.Error("oauth token issue", "client", client.ID, "error", err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+
+		w.Header().Set("Pragma", "no-cache")
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+func (s *Server) redeemCode(r *http.Request, client *Client) (*grant, error) {
+	code := r.PostForm.Get("code")
+	if code == "" {
+		return nil, errors.New("missing code")
+	}
+
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT client_id, user_id, redirect_uri, scopes, COALESCE(nonce, ''),
+               COALESCE(code_challenge, ''), auth_time, expires_at, used_at
+        FROM oauth_authorization_codes
+        WHERE code_hash = $1
+        FOR UPDATE
+    `
+
+	var (
+		g           grant
+		clientID    string
+		redirectURI string
+		challenge   string
+		expiresAt   time.Time
+		usedAt      sql.NullTime
+	)
+	err = tx.QueryRowContext(r.Context(), query, hashToken(code)).Scan(
+		&clientID, &g.userID, &redirectURI, pq.Array(&g.scopes), &g.nonce, &challenge, &g.authTime, &expiresAt, &usedAt,
+	)
+	if err != nil {
+		return nil, errors.New("invalid authorization code")
+	}
+
+	if usedAt.Valid {
+		// A replayed code means it leaked: revoke whatever it produced.
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE oauth_refresh_tokens SET used_at = now() WHERE user_id = $1 AND client_id = $2 AND used_at IS NULL`,
+			g.userID, clientID); err == nil {
+			tx.Commit()
+		}
+		return nil, errors.New("authorization code already used")
+	}
+
+	if clientID != client.ID || time.Now().After(expiresAt) {
+		return nil, errors.New("invalid authorization code")
+	}
+
+	if r.PostForm.Get("redirect_uri") != redirectURI {
+		return nil, errors.New("redirect_uri does not match the authorization request")
+	}
+
+	if challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		computed := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) != 1 {
+			return nil, errors.New("invalid code_verifier")
+		}
+	}
+
+	if _, err := tx.ExecContext(r.Context(), `UPDATE oauth_authorization_codes SET used_at = now() WHERE code_hash = $1`, hashToken(code)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func (s *Server) redeemRefreshToken(r *http.Request, client *Client) (*grant, error) {
+	token := r.PostForm.Get("refresh_token")
+	if token == "" {
+		return nil, errors.New("missing refresh_token")
+	}
+
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT client_id, user_id, scopes, auth_time, expires_at, used_at
+        FROM oauth_refresh_tokens
+        WHERE token_hash = $1
+        FOR UPDATE
+    `
+
+	var (
+		g         grant
+		clientID  string
+		expiresAt time.Time
+		usedAt    sql.NullTime
+	)
+	err = tx.QueryRowContext(r.Context(), query, hashToken(token)).Scan(
+		&clientID, &g.userID, pq.Array(&g.scopes), &g.authTime, &expiresAt, &usedAt,
+	)
+	if err != nil || clientID != client.ID {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	if usedAt.Valid {
+		// Rotated tokens are single use; seeing one again means it was
+		// stolen, so cut off every token this client holds for the user.
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE oauth_refresh_tokens SET used_at = now() WHERE user_id = $1 AND client_id = $2 AND used_at IS NULL`,
+			g.userID, clientID); err == nil {
+			tx.Commit()
+		}
+		return nil, errors.New("refresh token already used")
+	}
+
+	if time.Now().After(expiresAt) {
+		return nil, errors.New("refresh token expired")
+	}
+
+	// A client may ask for a narrower scope, never a wider one.
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		if !containsAll(g.scopes, requested) {
+			return nil, errors.New("scope exceeds the original grant")
+		}
+		g.scopes = requested
+	}
+
+	if _, err := tx.ExecContext(r.Context(), `UPDATE oauth_refresh_tokens SET used_at = now() WHERE token_hash = $1`, hashToken(token)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func (s *Server) issueTokens(r *http.Request, client *Client, g *grant) (*tokenResponse, error) {
+	user, err := s.loadUser(r.Context(), client.TenantID, g.userID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	scope := strings.Join(g.scopes, " ")
+
+	access, err := s.keyring.SignTyped("at+jwt", &AccessClaims{
+		Scope:    scope,
+		ClientID: client.ID,
+		Tenant:   client.TenantID,
+		AuthTime: g.authTime.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   g.userID.String(),
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+			ID:        uuid.NewString(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &tokenResponse{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+
+	if hasScope(g.scopes, "openid") {
+		id := &idClaims{
+			Nonce:    g.nonce,
+			AuthTime: g.authTime.Unix(),
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    s.issuer,
+				Subject:   g.userID.String(),
+				Audience:  jwt.ClaimStrings{client.ID},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(idTokenTTL)),
+			},
+		}
+		if hasScope(g.scopes, "email") {
+			id.Email = user.Email
+			id.EmailVerified = &user.EmailVerified
+		}
+		if hasScope(g.scopes, "profile") {
+			id.PreferredUsername = user.Username
+		}
+
+		if resp.IDToken, err = s.keyring.Sign(id); err != nil {
+			return nil, err
+		}
+	}
+
+	if hasScope(g.scopes, "offline_access") {
+		refresh, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+
+		query := `
+            INSERT INTO oauth_refresh_tokens (token_hash, client_id, user_id, scopes, auth_time, expires_at)
+            VALUES ($1, $2, $3, $4, $5, $6)
+        `
+		_, err = s.db.ExecContext(r.Context(), query, hashToken(refresh), client.ID, g.userID,
+			pq.Array(g.scopes), g.authTime, now.Add(refreshTokenTTL))
+		if err != nil {
+			return nil, err
+		}
+		resp.RefreshToken = refresh
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,61 @@
+package idp
+
+import (
+	"music-auth/internal/middleware"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// UserInfoHandler serves the OIDC userinfo endpoint. Only access tokens we
+// issued to a client (typ at+jwt) are accepted, not session tokens.
+func (s *Server) UserInfoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			oauthError(w, http.StatusUnauthorized, "invalid_request", "bearer token required")
+			return
+		}
+
+		claims := &AccessClaims{}
+		token, err := s.keyring.Parse(strings.TrimSpace(raw), claims, jwt.WithIssuer(s.issuer), jwt.WithExpirationRequired())
+		if err != nil || !token.Valid || token.Header["typ"] != "at+jwt" || claims.Tenant != middleware.GetTenant(r.Context()) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			oauthError(w, http.StatusUnauthorized, "invalid_token", "")
+			return
+		}
+
+		scopes := strings.Fields(claims.Scope)
+		if !hasScope(scopes, "openid") {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			oauthError(w, http.StatusForbidden, "insufficient_scope", "")
+			return
+		}
+
+		user, err := s.loadUser(r.Context(), claims.Tenant, claims.Subject)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			oauthError(w, http.StatusUnauthorized, "invalid_token", "")
+			return
+		}
+
+		info := map[string]any{"sub": claims.Subject}
+		if hasScope(scopes, "email") {
+			info["email"] = user.Email
+			info["email_verified"] = user.EmailVerified
+		}
+		if hasScope(scopes, "profile") {
+			info["preferred_username"] = user.Username
+		}
+
+		writeJSON(w, http.StatusOK, info)
+	})
+}
@@ -0,0 +1,398 @@
+// Package jobs runs background work from a Postgres table, so it survives
+// restarts and is shared by every instance of the server. Workers claim jobs
+// with SELECT ... FOR UPDATE SKIP LOCKED, failed jobs are retried with
+// exponential backoff, and jobs out of attempts are kept as dead letters.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultMaxAttempts = 5
+	baseBackoff        = 30 * time.Second
+	maxBackoff         = time.Hour
+	// lockSlack is added to a handler's timeout before another worker may
+	// assume the one holding the job has died.
+	lockSlack = time.Minute
+	// ShutdownGrace is how long running jobs get to finish once Run's
+	// context is done. Jobs cut off after that are run again later without
+	// counting as a failed attempt.
+	ShutdownGrace  = 20 * time.Second
+	deadJobTTL     = 30 * 24 * time.Hour
+	kindPrune      = "jobs.prune"
+	pruneInterval  = 24 * time.Hour
+	defaultTimeout = 5 * time.Minute
+)
+
+// Job is one claimed unit of work. Attempt counts from 1.
+type Job struct {
+	ID          uuid.UUID
+	Kind        string
+	Payload     json.RawMessage
+	Attempt     int
+	MaxAttempts int
+}
+
+// Decode unmarshals the payload into v.
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// LastAttempt reports whether a failure now dead-letters the job.
+func (j *Job) LastAttempt() bool {
+	return j.Attempt >= j.MaxAttempts
+}
+
+// Handler does the work of a job. An error retries it, unless wrapped with
+// Permanent.
+type Handler func(ctx context.Context, job *Job) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix, such as a malformed
+// payload. The job is dead-lettered at once.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Execer is a *sql.DB or *sql.Tx. Enqueueing inside the caller's transaction
+// makes the job exist exactly when the change that needs it commits.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type options struct {
+	runAt       time.Time
+	maxAttempts int
+	uniqueKey   *string
+}
+
+type Option func(*options)
+
+// At delays the job until t.
+func At(t time.Time) Option {
+	return func(o *options) { o.runAt = t }
+}
+
+// After delays the job by d.
+func After(d time.Duration) Option {
+	return func(o *options) { o.runAt = time.Now().Add(d) }
+}
+
+// MaxAttempts overrides how often the job is tried before it is dead.
+func MaxAttempts(n int) Option {
+	return func(o *options) { o.maxAttempts = n }
+}
+
+// Unique skips enqueueing while another queued or running job has key.
+func Unique(key string) Option {
+	return func(o *options) { o.uniqueKey = &key }
+}
+
+type handler struct {
+	fn      Handler
+	timeout time.Duration
+}
+
+// Queue enqueues and runs jobs. Handlers and schedules are registered before
+// Run.
+type Queue struct {
+	db           *sql.DB
+	handlers     map[string]handler
+	schedules    map[string]time.Duration
+	PollInterval time.Duration
+}
+
+func New(db *sql.DB) *Queue {
+	q := &Queue{
+		db:           db,
+		handlers:     map[string]handler{},
+		schedules:    map[string]time.Duration{},
+		PollInterval: time.Second,
+	}
+
+	q.Register(kindPrune, time.Minute, q.prune)
+	q.Schedule(kindPrune, pruneInterval)
+
+	return q
+}
+
+// Register sets the handler for a kind of job. A job that runs longer than
+// timeout is cancelled. Only kinds registered on an instance are claimed by
+// it.
+func (q *Queue) Register(kind string, timeout time.Duration, fn Handler) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	q.handlers[kind] = handler{fn: fn, timeout: timeout}
+}
+
+// Schedule runs a registered kind every interval, once across all instances.
+func (q *Queue) Schedule(kind string, every time.Duration) {
+	q.schedules[kind] = every
+}
+
+func scheduleKey(kind string) string {
+	return "schedule:" + kind
+}
+
+// Enqueue adds a job with a JSON payload. With ex nil the job is added on
+// its own.
+func (q *Queue) Enqueue(ctx context.Context, ex Execer, kind string, payload any, opts ...Option) error {
+	o := options{runAt: time.Now(), maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode %s job: %w", kind, err)
+	}
+
+	if ex == nil {
+		ex = q.db
+	}
+
+	query := `
+        INSERT INTO jobs (kind, payload, run_at, max_attempts, unique_key)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (unique_key) WHERE status IN ('queued', 'running') DO NOTHING
+    `
+
+	if _, err := ex.ExecContext(ctx, query, kind, data, o.runAt, o.maxAttempts, o.uniqueKey); err != nil {
+		return fmt.Errorf("enqueue %s job: %w", kind, err)
+	}
+	return nil
+}
+
+// Run processes jobs with the given number of workers until ctx is done,
+// then waits for running jobs, up to ShutdownGrace, before it returns.
+func (q *Queue) Run(ctx context.Context, workers int) {
+	for kind := range q.schedules {
+		if err := q.Enqueue(ctx, nil, kind, nil, Unique(scheduleKey(kind))); err != nil {
+			slog.Error("schedule job", "kind", kind, "error", err)
+		}
+	}
+
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	// Running jobs outlive ctx by the grace period.
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(ShutdownGrace, cancel)
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, jobCtx, kinds)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx, jobCtx context.Context, kinds []string) {
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx, kinds)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("claim job", "error", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.PollInterval):
+			}
+			continue
+		}
+
+		q.execute(jobCtx, job)
+	}
+}
+
+func (q *Queue) claim(ctx context.Context, kinds []string) (*Job, error) {
+	query := `
+        UPDATE jobs j
+        SET status = 'running',
+            attempts = j.attempts + 1,
+            locked_until = now() + make_interval(secs => $2),
+            updated_at = now()
+        WHERE j.id = (
+            SELECT id FROM jobs
+            WHERE kind = ANY($1)
+              AND ((status = 'queued' AND run_at <= now())
+                   OR (status = 'running' AND locked_until < now()))
+            ORDER BY run_at
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING j.id, j.kind, j.payload, j.attempts, j.max_attempts
+    `
+
+	// The lock has to cover the slowest kind; the claimed job's own timeout
+	// is only known afterwards.
+	var longest time.Duration
+	for _, h := range q.handlers {
+		longest = max(longest, h.timeout)
+	}
+
+	var job Job
+	err := q.db.QueryRowContext(ctx, query, pq.Array(kinds), (longest+lockSlack).Seconds()).Scan(
+		&job.ID, &job.Kind, &job.Payload, &job.Attempt, &job.MaxAttempts,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (q *Queue) execute(ctx context.Context, job *Job) {
+	h := q.handlers[job.Kind]
+
+	runCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	err := run(runCtx, h.fn, job)
+	cancel()
+
+	// Results are recorded even when shutdown cut the job off.
+	recordCtx := context.WithoutCancel(ctx)
+
+	switch {
+	case err == nil:
+		err = q.finish(recordCtx, job)
+	case ctx.Err() != nil:
+		err = q.release(recordCtx, job)
+	default:
+		slog.Error("job failed", "kind", job.Kind, "job_id", job.ID, "attempt", job.Attempt, "error", err)
+		err = q.fail(recordCtx, job, err)
+	}
+	if err != nil {
+		slog.Error("record job result", "kind", job.Kind, "job_id", job.ID, "error", err)
+	}
+}
+
+// run calls the handler, turning a panic into an error.
+func run(ctx context.Context, fn Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, job)
+}
+
+// finish deletes a done job. Payloads can hold links with tokens, so
+// nothing is kept.
+func (q *Queue) finish(ctx context.Context, job *Job) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM jobs WHERE id = $1`, job.ID); err != nil {
+		return err
+	}
+	if err := q.reschedule(ctx, tx, job.Kind); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// release hands an interrupted job back without using up an attempt.
+func (q *Queue) release(ctx context.Context, job *Job) error {
+	query := `
+        UPDATE jobs
+        SET status = 'queued', attempts = attempts - 1, run_at = now(), locked_until = NULL, updated_at = now()
+        WHERE id = $1
+    `
+	_, err := q.db.ExecContext(ctx, query, job.ID)
+	return err
+}
+
+// fail schedules a retry, or dead-letters the job when it is out of
+// attempts or the error is permanent.
+func (q *Queue) fail(ctx context.Context, job *Job, cause error) error {
+	var permanent permanentError
+	dead := job.LastAttempt() || errors.As(cause, &permanent)
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE jobs
+        SET status = CASE WHEN $2 THEN 'dead' ELSE 'queued' END,
+            run_at = now() + make_interval(secs => $3),
+            last_error = $4,
+            locked_until = NULL,
+            updated_at = now()
+        WHERE id = $1
+    `
+	if _, err := tx.ExecContext(ctx, query, job.ID, dead, backoff(job.Attempt).Seconds(), cause.Error()); err != nil {
+		return err
+	}
+
+	// A dead scheduled job must not end its schedule.
+	if dead {
+		if err := q.reschedule(ctx, tx, job.Kind); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (q *Queue) reschedule(ctx context.Context, tx *sql.Tx, kind string) error {
+	every, ok := q.schedules[kind]
+	if !ok {
+		return nil
+	}
+	return q.Enqueue(ctx, tx, kind, nil, After(every), Unique(scheduleKey(kind)))
+}
+
+// backoff doubles from baseBackoff up to maxBackoff, with jitter so that
+// jobs failing together do not retry together.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 20 {
+		d = min(baseBackoff<<(attempt-1), maxBackoff)
+	}
+	return d + rand.N(d/5+1)
+}
+
+func (q *Queue) prune(ctx context.Context, _ *Job) error {
+	query := `DELETE FROM jobs WHERE status = 'dead' AND updated_at < now() - make_interval(secs => $1)`
+	_, err := q.db.ExecContext(ctx, query, deadJobTTL.Seconds())
+	return err
+}
@@ -0,0 +1,481 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+
+	// jwksMaxAge is how long clients may cache the JWKS document.
+	jwksMaxAge = 5 * time.Minute
+	// keyPublishDelay is how long a new key is only published before it
+	// signs: long enough for every replica to reload the directory and for
+	// cached JWKS documents to expire, so all of them can verify its tokens.
+	keyPublishDelay = jwksMaxAge + 2*time.Minute
+)
+
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	CreatedAt time.Time
+	// PublishedAt is when the key file was written, which can be later than
+	// CreatedAt for keys named after their rotation window.
+	PublishedAt time.Time
+
+	signKey   any
+	verifyKey any
+}
+
+// Keyring holds every key that may have signed a token still in circulation.
+// The newest key published for keyPublishDelay (or JWT_ACTIVE_KID) signs; all
+// of them verify.
+type Keyring struct {
+	alg       string
+	dir       string
+	activeKID string
+	rotation  time.Duration
+	retention time.Duration
+
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active *Key
+}
+
+// InitKeyring builds the keyring from the environment:
+//
+//	JWT_SIGNING_ALG           HS256 (default), RS256 or EdDSA
+//	JWT_SECRET                the HS256 key
+//	JWT_KEYS_DIR              directory of <kid>.pem PKCS#8 private keys
+//	JWT_ACTIVE_KID            pin the signing key instead of using the newest
+//	JWT_KEY_ROTATION_INTERVAL generate a new key when the active one is older
+//	JWT_KEY_RETENTION         delete keys this long after they were superseded
+func InitKeyring(secret string) (*Keyring, error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = AlgHS256
+	}
+
+	k := &Keyring{
+		alg:       alg,
+		dir:       os.Getenv("JWT_KEYS_DIR"),
+		activeKID: os.Getenv("JWT_ACTIVE_KID"),
+		keys:      map[string]*Key{},
+	}
+
+	var err error
+	if k.rotation, err = durationEnv("JWT_KEY_ROTATION_INTERVAL"); err != nil {
+		return nil, err
+	}
+	if k.retention, err = durationEnv("JWT_KEY_RETENTION"); err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case AlgHS256:
+		if secret == "" {
+			return nil, fmt.Errorf("JWT_SECRET is required for HS256")
+		}
+		// The shared secret is the only key; there is nothing to rotate.
+		k.dir = ""
+		key := &Key{ID: "hs256", Method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+		k.keys[key.ID] = key
+		k.active = key
+		return k, nil
+
+	case AlgRS256, AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", alg)
+	}
+
+	if k.dir == "" {
+		slog.Warn("JWT_KEYS_DIR not set, using an ephemeral signing key; tokens will not survive a restart")
+		key, err := generateKey(alg, newKID(time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = key
+		k.active = key
+		return k, nil
+	}
+
+	if err := os.MkdirAll(k.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create keys dir: %w", err)
+	}
+
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func durationEnv(name string) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// Reload re-reads JWT_KEYS_DIR, generating a key when there is none or the
+// newest one is due for rotation, and drops keys past their retention.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	keys, err := loadDir(k.dir, k.alg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	newest := newestKey(keys)
+
+	if newest == nil || (k.rotation > 0 && now.Sub(newest.CreatedAt) >= k.rotation) {
+		// Name the key after the rotation window so replicas racing here all
+		// try to create the same file and exactly one of them wins.
+		created := now
+		if k.rotation > 0 {
+			created = now.Truncate(k.rotation)
+		}
+		key, err := writeKey(k.dir, k.alg, newKID(created), created)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		if key != nil {
+			slog.Info("generated JWT signing key", "kid", key.ID)
+		}
+		if keys, err = loadDir(k.dir, k.alg); err != nil {
+			return err
+		}
+		newest = newestKey(keys)
+	}
+
+	if k.retention > 0 {
+		k.prune(keys, now)
+	}
+
+	active := signingKey(keys, now)
+	if k.activeKID != "" {
+		pinned, ok := keys[k.activeKID]
+		if !ok {
+			return fmt.Errorf("JWT_ACTIVE_KID %q not found in %s", k.activeKID, k.dir)
+		}
+		active = pinned
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.active = active
+	k.mu.Unlock()
+
+	return nil
+}
+
+// prune deletes keys that were superseded more than retention ago. A key is
+// superseded when the next newer key started signing.
+func (k *Keyring) prune(keys map[string]*Key, now time.Time) {
+	ordered := sortedKeys(keys)
+	for i := 0; i < len(ordered)-1; i++ {
+		key := ordered[i]
+		if key.ID == k.activeKID {
+			continue
+		}
+		supersededAt := ordered[i+1].PublishedAt.Add(keyPublishDelay)
+		if now.Sub(supersededAt) < k.retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(k.dir, key.ID+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("remove retired JWT key", "kid", key.ID, "error", err)
+			continue
+		}
+		delete(keys, key.ID)
+		slog.Info("retired JWT signing key", "kid", key.ID)
+	}
+}
+
+// Run reloads the keyring every interval until ctx is cancelled, which picks
+// up keys added by other replicas and performs scheduled rotation.
+func (k *Keyring) Run(ctx context.Context, interval time.Duration) {
+	if k.dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Reload(); err != nil {
+				slog.Error("reload JWT keys", "error", err)
+			}
+		}
+	}
+}
+
+// Sign signs claims with the active key and stamps its kid in the header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+
+	token := jwt.NewWithClaims(active.Method, claims)
+	token.Header["kid"] = active.ID
+	return token.SignedString(active.signKey)
+}
+
+// SignTyped is Sign with an explicit "typ" header, e.g. "at+jwt" for OAuth
+// access tokens (RFC 9068), so they cannot be confused with ID tokens.
+func (k *Keyring) SignTyped(typ string, claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+
+	token := jwt.NewWithClaims(active.Method, claims)
+	token.Header["kid"] = active.ID
+	token.Header["typ"] = typ
+	return token.SignedString(active.signKey)
+}
+
+// Keyfunc resolves the verification key for a token by its kid.
+func (k *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var key *Key
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = k.keys[kid]
+	} else if k.alg == AlgHS256 {
+		// Tokens minted before kids were introduced.
+		key = k.active
+	}
+
+	if key == nil {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+
+	return key.verifyKey, nil
+}
+
+// Parse verifies tokenStr against the keyring and fills claims.
+func (k *Keyring) Parse(tokenStr string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{k.alg}))
+	return jwt.ParseWithClaims(tokenStr, claims, k.Keyfunc, opts...)
+}
+
+func (k *Keyring) Algorithm() string {
+	return k.alg
+}
+
+// Generate writes a brand new key into dir, for the `keys generate` command.
+func Generate(dir, alg string) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create keys dir: %w", err)
+	}
+	now := time.Now()
+	key, err := writeKey(dir, alg, newKID(now), now)
+	if err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+// newKID encodes the creation time so that kids sort chronologically.
+func newKID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func generateKey(alg, kid string) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+
+	switch alg {
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("cannot generate keys for %s", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate %s key: %w", alg, err)
+	}
+
+	return newKey(kid, signer, time.Now())
+}
+
+func writeKey(dir, alg, kid string, created time.Time) (*Key, error) {
+	key, err := generateKey(alg, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.signKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal key: %w", err)
+	}
+
+	// Write to a temp file and hard-link it into place so other replicas
+	// never see a partially written key, and a kid that already exists fails
+	// with os.ErrExist.
+	tmp, err := os.CreateTemp(dir, ".tmp-"+kid+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("write key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = pem.Encode(tmp, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write key: %w", err)
+	}
+
+	if err := os.Link(tmp.Name(), filepath.Join(dir, kid+".pem")); err != nil {
+		return nil, err
+	}
+
+	key.CreatedAt = created
+	return key, nil
+}
+
+func loadDir(dir, alg string) (map[string]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*Key{}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read key %s: %w", kid, err)
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("key %s: no PEM block", kid)
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %s: unsupported key type %T", kid, parsed)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := time.Parse("20060102T150405Z", kid)
+		if err != nil {
+			created = info.ModTime()
+		}
+
+		key, err := newKey(kid, signer, created)
+		if err != nil {
+			return nil, err
+		}
+		key.PublishedAt = info.ModTime()
+		if key.Method.Alg() != alg {
+			// Keys of another algorithm may sit in the directory during a
+			// migration; they cannot verify tokens we accept, so skip them.
+			continue
+		}
+
+		keys[kid] = key
+	}
+
+	return keys, nil
+}
+
+func newKey(kid string, signer crypto.Signer, created time.Time) (*Key, error) {
+	key := &Key{ID: kid, CreatedAt: created, signKey: signer, verifyKey: signer.Public()}
+
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", kid, signer)
+	}
+
+	return key, nil
+}
+
+func sortedKeys(keys map[string]*Key) []*Key {
+	out := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, key)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+// signingKey picks the newest key that has been published for
+// keyPublishDelay. When none has, e.g. on the very first start, the newest
+// key signs right away: no token can be in circulation yet.
+func signingKey(keys map[string]*Key, now time.Time) *Key {
+	ordered := sortedKeys(keys)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if now.Sub(ordered[i].PublishedAt) >= keyPublishDelay {
+			return ordered[i]
+		}
+	}
+	return newestKey(keys)
+}
+
+func newestKey(keys map[string]*Key) *Key {
+	ordered := sortedKeys(keys)
+	if len(ordered) == 0 {
+		return nil
+	}
+	return ordered[len(ordered)-1]
+}
@@ -0,0 +1,91 @@
+package keys
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	remoteJWKSTTL         = time.Hour
+	remoteJWKSMinInterval = 30 * time.Second
+)
+
+// RemoteJWKS verifies tokens signed by another issuer using its published
+// JWKS. Keys are cached and refetched when stale or when an unknown kid shows
+// up, which is how issuers announce rotation.
+type RemoteJWKS struct {
+	URL    string
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func NewRemoteJWKS(url string, client *http.Client) *RemoteJWKS {
+	return &RemoteJWKS{URL: url, Client: client}
+}
+
+func (r *RemoteJWKS) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[kid]
+	stale := time.Since(r.fetched) > remoteJWKSTTL
+	if (!ok || stale) && time.Since(r.fetched) > remoteJWKSMinInterval {
+		if err := r.fetch(); err != nil {
+			if !ok {
+				return nil, err
+			}
+		} else {
+			key, ok = r.keys[kid]
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (r *RemoteJWKS) fetch() error {
+	resp, err := r.Client.Get(r.URL)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: %s", resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = pub
+	}
+
+	r.keys = keys
+	r.fetched = time.Now()
+	return nil
+}
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer delivers transactional email (verification links, password resets).
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// InitMailer picks an implementation from MAIL_DRIVER:
+//
+//	smtp   – SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM
+//	file   – writes .eml files into MAIL_DIR (default "mail-out")
+//	memory – keeps messages in memory, for tests
+//
+// Without MAIL_DRIVER it uses smtp when SMTP_HOST is set and file otherwise.
+func InitMailer() (Mailer, error) {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" {
+		if os.Getenv("SMTP_HOST") != "" {
+			driver = "smtp"
+		} else {
+			driver = "file"
+		}
+	}
+
+	switch driver {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required")
+		}
+
+		port := 587
+		if p := os.Getenv("SMTP_PORT"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+			}
+			port = n
+		}
+
+		from := os.Getenv("MAIL_FROM")
+		if from == "" {
+			return nil, fmt.Errorf("MAIL_FROM is required")
+		}
+
+		return &SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail-out"
+		}
+		slog.Warn("outgoing mail is written to disk, not delivered", "dir", dir)
+		return NewFileMailer(dir)
+
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+}
@@ -2,20 +2,37 @@ package middleware
 
 import (
 	"context"
+	"log/slog"
 	"music-auth/internal/common"
+	"music-auth/internal/keys"
+	"net"
 	"net/http"
+	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type responseWriterKey struct{}
+type requestKey struct{}
 
 type userContextKey string
+
 const UserContextKey userContextKey = "user"
 
+// TrustProxyHeaders makes ClientIP honour X-Forwarded-For. Only enable it
+// when the service sits behind a proxy that overwrites the header.
+var TrustProxyHeaders = false
+
+// SessionStore is the server-side revocation list consulted for every
+// authenticated request.
+type SessionStore interface {
+	IsSessionActive(ctx context.Context, userID, sessionID uuid.UUID) (bool, error)
+}
+
 func ResponseWriterMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := context.WithValue(r.Context(), responseWriterKey{}, w)
+		ctx = context.WithValue(ctx, requestKey{}, r)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -26,33 +43,80 @@ func GetResponseWriter(ctx context.Context) http.ResponseWriter {
 	}
 	return nil
 }
-func AuthMiddleware(secret []byte, next http.Handler) http.Handler {
+
+// GetRequest returns the incoming HTTP request so resolvers can read cookies
+// other than auth_token (e.g. refresh_token).
+func GetRequest(ctx context.Context) *http.Request {
+	if r, ok := ctx.Value(requestKey{}).(*http.Request); ok {
+		return r
+	}
+	return nil
+}
+
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func AuthMiddleware(keyring *keys.Keyring, sessions SessionStore, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth_token")
+		cookie, err := r.Cookie(AuthCookieName)
 		if err != nil {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		claims := &common.Claims{}
-		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return secret, nil
-		})
+		token, err := keyring.Parse(cookie.Value, claims)
 
 		if err != nil || !token.Valid {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// A session from one storefront is worthless on another.
+		if claims.Tenant != GetTenant(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		active, err := sessions.IsSessionActive(r.Context(), claims.UserID, claims.SessionID)
+		if err != nil {
+			slog.Error("session lookup failed", "error", err)
+		}
+		if !active {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), UserContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func GetUserFromContext(ctx context.Context) (*common.Claims, bool) {
-    claims, ok := ctx.Value(UserContextKey).(*common.Claims)
-    return claims, ok
+	claims, ok := ctx.Value(UserContextKey).(*common.Claims)
+	return claims, ok
+}
+
+// CurrentUser returns the caller of a resolver guarded by @auth, @hasRole or
+// @hasPermission, which have already rejected anonymous requests. Reaching it
+// without a user means a schema field lost its directive, so it panics
+// rather than act on behalf of nobody.
+func CurrentUser(ctx context.Context) *common.Claims {
+	claims, ok := GetUserFromContext(ctx)
+	if !ok {
+		panic("middleware.CurrentUser: no authenticated user, is the field missing @auth?")
+	}
+	return claims
 }
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	AuthCookieName    = "auth_token"
+	RefreshCookieName = "refresh_token"
+
+	// The refresh token is only ever needed by the refreshSession mutation,
+	// so keep it off every other path.
+	RefreshCookiePath = "/service"
+
+	// MFACookieName carries the MFA challenge of a social login to the
+	// verifyMFA mutation, so the token never appears in a URL.
+	MFACookieName = "mfa_token"
+)
+
+func SetSessionCookies(w http.ResponseWriter, accessToken string, accessExp time.Time, refreshToken string, refreshExp time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthCookieName,
+		Value:    accessToken,
+		Expires:  accessExp,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    refreshToken,
+		Expires:  refreshExp,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     RefreshCookiePath,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func ClearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{AuthCookieName: "/", RefreshCookieName: RefreshCookiePath} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: true,
+			Path:     path,
+		})
+	}
+}
+
+func SetMFACookie(w http.ResponseWriter, token string, exp time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     MFACookieName,
+		Value:    token,
+		Expires:  exp,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     RefreshCookiePath,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearMFACookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     MFACookieName,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     RefreshCookiePath,
+	})
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"music-auth/internal/ratelimit"
+	"net/http"
+	"strconv"
+)
+
+// RateLimitMiddleware gives each logged-in user a token bucket from users
+// and every other client one per IP from anonymous. It must run inside
+// AuthMiddleware to tell the two apart. Throttled requests get a 429 with a
+// Retry-After header and a GraphQL error body.
+func RateLimitMiddleware(users, anonymous *ratelimit.TokenBuckets, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if claims, ok := GetUserFromContext(r.Context()); ok {
+			err = users.Take("user:" + claims.UserID.String())
+		} else {
+			err = anonymous.Take("ip:" + ClientIP(r))
+		}
+
+		var limited *ratelimit.Error
+		if errors.As(err, &limited) {
+			writeRateLimited(w, limited)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeRateLimited(w http.ResponseWriter, limited *ratelimit.Error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]any{{
+			"message":    limited.Error(),
+			"extensions": limited.Extensions(),
+		}},
+	})
+}
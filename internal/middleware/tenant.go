@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// TenantHeader selects the tenant explicitly, e.g. for API clients or a
+// gateway serving several storefronts from one host.
+const TenantHeader = "X-Tenant-ID"
+
+// DefaultTenant serves requests whose host is not registered to any tenant.
+// When empty such requests are rejected.
+var DefaultTenant = "music-store"
+
+type tenantKey struct{}
+
+// TenantResolver maps request hosts to tenants.
+type TenantResolver interface {
+	TenantForHost(ctx context.Context, host string) (string, bool, error)
+	TenantExists(ctx context.Context, id string) (bool, error)
+}
+
+// TenantMiddleware resolves the tenant of every request, from TenantHeader
+// if present and otherwise from the Host. It must run before AuthMiddleware,
+// which only accepts tokens issued for the same tenant.
+func TenantMiddleware(tenants TenantResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(TenantHeader)
+
+		if id != "" {
+			ok, err := tenants.TenantExists(r.Context(), id)
+			if err != nil {
+				slog.Error("tenant lookup failed", "error", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "unknown tenant", http.StatusBadRequest)
+				return
+			}
+		} else {
+			found, ok, err := tenants.TenantForHost(r.Context(), r.Host)
+			if err != nil {
+				slog.Error("tenant lookup failed", "error", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			id = found
+			if !ok {
+				id = DefaultTenant
+			}
+		}
+
+		if id == "" {
+			http.Error(w, "unknown tenant", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), id)))
+	})
+}
+
+// WithTenant is for flows that carry the tenant themselves, such as the
+// social login callback.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// GetTenant returns the tenant of the request, or "" outside
+// TenantMiddleware, which matches no rows.
+func GetTenant(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+	return id
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBuckets gives every key a bucket of burst tokens that refills at rate
+// tokens per second; each request takes one. Buckets live in process: they
+// are consulted on every request, which is too often for a database round
+// trip.
+type TokenBuckets struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewTokenBuckets(rate float64, burst int) *TokenBuckets {
+	return &TokenBuckets{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Take spends a token of key's bucket. If the bucket is empty it returns an
+// *Error saying when the next token will be there.
+func (t *TokenBuckets) Take(key string) error {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &bucket{tokens: t.burst, last: now}
+		t.buckets[key] = b
+	}
+
+	b.tokens = min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return &Error{RetryAfter: time.Duration((1 - b.tokens) / t.rate * float64(time.Second))}
+	}
+
+	b.tokens--
+	return nil
+}
+
+// sweep forgets buckets that have refilled completely, since a new bucket
+// starts out full anyway.
+func (t *TokenBuckets) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	full := time.Duration(t.burst / t.rate * float64(time.Second))
+	for key, b := range t.buckets {
+		if now.Sub(b.last) > full {
+			delete(t.buckets, key)
+		}
+	}
+}
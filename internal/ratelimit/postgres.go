@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps counters in the rate_limits table, so every instance
+// of the service sees the same counts.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Entry, error) {
+	var e Entry
+	query := `SELECT hits, last_hit_at FROM rate_limits WHERE key = $1 AND expires_at > now()`
+
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&e.Count, &e.Last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, nil
+	}
+	return e, err
+}
+
+func (s *PostgresStore) Hit(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error) {
+	// One statement, so concurrent attempts cannot both read the old count.
+	query := `
+        INSERT INTO rate_limits (key, hits, last_hit_at, expires_at)
+        VALUES ($1, 1, $2, $3)
+        ON CONFLICT (key) DO UPDATE
+        SET hits = CASE WHEN rate_limits.expires_at <= EXCLUDED.last_hit_at THEN 1 ELSE rate_limits.hits + 1 END,
+            last_hit_at = EXCLUDED.last_hit_at,
+            expires_at = EXCLUDED.expires_at
+        RETURNING hits, last_hit_at
+    `
+
+	var e Entry
+	err := s.db.QueryRowContext(ctx, query, key, now, now.Add(window)).Scan(&e.Count, &e.Last)
+	return e, err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE key = $1`, key)
+	return err
+}
+
+func (s *PostgresStore) Prune(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE expires_at <= now()`)
+	return err
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+)
+
+// Entry is what a store remembers about one key: how many hits it has seen
+// since the window last started over, and when the latest one was.
+type Entry struct {
+	Count int
+	Last  time.Time
+}
+
+// Store keeps hit counters for limiters. One store is shared by every
+// limiter, so keys must be namespaced by the caller.
+type Store interface {
+	// Get returns the entry for key, or a zero Entry if it has expired.
+	Get(ctx context.Context, key string) (Entry, error)
+	// Hit records a hit at now and returns the updated entry. The count
+	// starts over when the previous hit is more than window ago.
+	Hit(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error)
+	Reset(ctx context.Context, key string) error
+	// Prune drops expired entries.
+	Prune(ctx context.Context) error
+}
+
+// InitStore picks an implementation from RATE_LIMIT_STORE:
+//
+//	postgres – the rate_limits table, shared by every instance (default)
+//	memory   – per process, for a single instance or tests
+func InitStore(db *sql.DB) (Store, error) {
+	switch driver := os.Getenv("RATE_LIMIT_STORE"); driver {
+	case "", "postgres":
+		return NewPostgresStore(db), nil
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", driver)
+	}
+}
+
+// Run prunes the store every interval until ctx is done.
+func Run(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Prune(ctx); err != nil {
+				slog.Error("prune rate limits", "error", err)
+			}
+		}
+	}
+}
+
+// Policy turns a hit count into a wait. The first Free hits cost nothing;
+// after that each one doubles the wait, starting at BaseDelay and capped at
+// MaxDelay. From LockoutAfter hits on, the key is locked for Lockout.
+// Counts start over once a key has been quiet for Window.
+type Policy struct {
+	Free         int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int
+	Lockout      time.Duration
+	Window       time.Duration
+}
+
+func (p Policy) wait(n int) time.Duration {
+	if p.LockoutAfter > 0 && n >= p.LockoutAfter {
+		return p.Lockout
+	}
+	if n < p.Free {
+		return 0
+	}
+
+	shift := n - p.Free
+	if shift >= 62 || p.BaseDelay > time.Duration(math.MaxInt64>>shift) {
+		return p.MaxDelay
+	}
+	return min(p.BaseDelay<<shift, p.MaxDelay)
+}
+
+// Error is returned for a key that has to wait.
+type Error struct {
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("too many attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// Extensions implements gqlerror.ExtendedError so GraphQL clients get a
+// machine-readable hint.
+func (e *Error) Extensions() map[string]any {
+	return map[string]any{
+		"code":       "RATE_LIMITED",
+		"retryAfter": int(math.Ceil(e.RetryAfter.Seconds())),
+	}
+}
+
+// Limiter applies one policy to keys kept in a store.
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func New(store Store, policy Policy) *Limiter {
+	// A lockout must not outlive the entry that records it.
+	policy.Window = max(policy.Window, policy.Lockout, policy.MaxDelay)
+
+	return &Limiter{store: store, policy: policy, now: time.Now}
+}
+
+// Allow returns an *Error if key has to wait before its next attempt.
+func (l *Limiter) Allow(ctx context.Context, key string) error {
+	entry, err := l.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return l.check(entry)
+}
+
+// Hit records an attempt against key (a failed login, a sign-up) and
+// returns an *Error if the next attempt now has to wait.
+func (l *Limiter) Hit(ctx context.Context, key string) error {
+	entry, err := l.store.Hit(ctx, key, l.now(), l.policy.Window)
+	if err != nil {
+		return err
+	}
+	return l.check(entry)
+}
+
+// Reset forgets key, e.g. after a successful login.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
+
+func (l *Limiter) check(entry Entry) error {
+	if entry.Count == 0 {
+		return nil
+	}
+
+	wait := entry.Last.Add(l.policy.wait(entry.Count)).Sub(l.now())
+	if wait > 0 {
+		return &Error{RetryAfter: wait}
+	}
+	return nil
+}
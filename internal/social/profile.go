@@ -0,0 +1,166 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"music-auth/internal/auth"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// flexBool accepts both true and "true": some providers send email_verified
+// as a string.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = flexBool(s == "true")
+	return nil
+}
+
+type oidcClaims struct {
+	Email             string   `json:"email"`
+	EmailVerified     flexBool `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Nonce             string   `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// identityFromIDToken verifies an ID token issued to us by an OIDC provider.
+func identityFromIDToken(p *Provider, idToken, nonce string) (*auth.ExternalIdentity, error) {
+	claims := &oidcClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims, p.jwks.Keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("invalid id token: missing subject")
+	}
+
+	return &auth.ExternalIdentity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+// fillFromUserInfo completes an identity whose ID token left out the email.
+func fillFromUserInfo(ctx context.Context, client *http.Client, p *Provider, accessToken string, identity *auth.ExternalIdentity) error {
+	if p.UserInfoURL == "" {
+		return nil
+	}
+
+	var info struct {
+		Sub               string   `json:"sub"`
+		Email             string   `json:"email"`
+		EmailVerified     flexBool `json:"email_verified"`
+		PreferredUsername string   `json:"preferred_username"`
+	}
+	if err := getJSON(ctx, client, p.UserInfoURL, accessToken, &info); err != nil {
+		return err
+	}
+
+	if info.Sub != identity.Subject {
+		return errors.New("userinfo subject does not match id token")
+	}
+
+	identity.Email = info.Email
+	identity.EmailVerified = bool(info.EmailVerified)
+	if identity.Username == "" {
+		identity.Username = info.PreferredUsername
+	}
+
+	return nil
+}
+
+func githubProfile(ctx context.Context, client *http.Client, p *Provider, accessToken string) (*auth.ExternalIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, client, p.UserInfoURL, accessToken, &user); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, strings.TrimSuffix(p.UserInfoURL, "/user")+"/user/emails", accessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &auth.ExternalIdentity{
+		Provider: p.Name,
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Username: user.Login,
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+
+	return identity, nil
+}
+
+func spotifyProfile(ctx context.Context, client *http.Client, p *Provider, accessToken string) (*auth.ExternalIdentity, error) {
+	var me struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(ctx, client, p.UserInfoURL, accessToken, &me); err != nil {
+		return nil, err
+	}
+
+	// Spotify does not say whether the address was verified.
+	return &auth.ExternalIdentity{
+		Provider: p.Name,
+		Subject:  me.ID,
+		Email:    me.Email,
+		Username: me.ID,
+	}, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,192 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"music-auth/internal/auth"
+	"music-auth/internal/keys"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Provider describes an OAuth2 authorization server we accept logins from.
+// OIDC providers only need Issuer: the endpoints are discovered and the ID
+// token is verified against the provider's JWKS. Plain OAuth2 providers set
+// the endpoints and a profile fetcher instead.
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	Issuer string
+
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	JWKSURL     string
+
+	// SecretInHeader sends the client credentials with HTTP Basic auth
+	// instead of in the token request body.
+	SecretInHeader bool
+
+	fetchProfile func(ctx context.Context, client *http.Client, p *Provider, accessToken string) (*auth.ExternalIdentity, error)
+
+	discoverMu sync.Mutex
+	discovered bool
+	jwks       *keys.RemoteJWKS
+}
+
+func (p *Provider) isOIDC() bool {
+	return p.Issuer != ""
+}
+
+func google() *Provider {
+	return &Provider{
+		Name:   "google",
+		Issuer: "https://accounts.google.com",
+		Scopes: []string{"openid", "email", "profile"},
+	}
+}
+
+func github() *Provider {
+	return &Provider{
+		Name:         "github",
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		Scopes:       []string{"read:user", "user:email"},
+		fetchProfile: githubProfile,
+	}
+}
+
+func spotify() *Provider {
+	return &Provider{
+		Name:           "spotify",
+		AuthURL:        "https://accounts.spotify.com/authorize",
+		TokenURL:       "https://accounts.spotify.com/api/token",
+		UserInfoURL:    "https://api.spotify.com/v1/me",
+		Scopes:         []string{"user-read-email", "user-read-private"},
+		SecretInHeader: true,
+		fetchProfile:   spotifyProfile,
+	}
+}
+
+// InitProviders reads SOCIAL_PROVIDERS, a comma separated list of google,
+// github, spotify and oidc. Each needs <NAME>_CLIENT_ID and
+// <NAME>_CLIENT_SECRET; oidc also needs OIDC_ISSUER and accepts OIDC_SCOPES,
+// which makes it the one to point at a local stand-in provider.
+func InitProviders() (map[string]*Provider, error) {
+	providers := map[string]*Provider{}
+
+	for _, name := range strings.Split(os.Getenv("SOCIAL_PROVIDERS"), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+
+		var p *Provider
+		switch name {
+		case "google":
+			p = google()
+		case "github":
+			p = github()
+		case "spotify":
+			p = spotify()
+		case "oidc":
+			issuer := os.Getenv("OIDC_ISSUER")
+			if issuer == "" {
+				return nil, fmt.Errorf("OIDC_ISSUER is required for the oidc provider")
+			}
+			p = &Provider{
+				Name:   "oidc",
+				Issuer: strings.TrimRight(issuer, "/"),
+				Scopes: []string{"openid", "email", "profile"},
+			}
+			if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+				p.Scopes = strings.Fields(scopes)
+			}
+		default:
+			return nil, fmt.Errorf("unknown social provider %q", name)
+		}
+
+		prefix := strings.ToUpper(name)
+		p.ClientID = os.Getenv(prefix + "_CLIENT_ID")
+		p.ClientSecret = os.Getenv(prefix + "_CLIENT_SECRET")
+		if p.ClientID == "" || p.ClientSecret == "" {
+			return nil, fmt.Errorf("%s_CLIENT_ID and %s_CLIENT_SECRET are required", prefix, prefix)
+		}
+
+		providers[name] = p
+	}
+
+	return providers, nil
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// discover fills in endpoints of an OIDC provider from its discovery
+// document. A failure is retried on the next login.
+func (p *Provider) discover(ctx context.Context, client *http.Client) error {
+	if !p.isOIDC() {
+		return nil
+	}
+
+	p.discoverMu.Lock()
+	defer p.discoverMu.Unlock()
+
+	if p.discovered {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s discovery: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s discovery: %s", p.Name, resp.Status)
+	}
+
+	var doc discoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("%s discovery: %w", p.Name, err)
+	}
+
+	if strings.TrimRight(doc.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("%s discovery: issuer mismatch %q", p.Name, doc.Issuer)
+	}
+
+	if p.AuthURL == "" {
+		p.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = doc.TokenEndpoint
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = doc.UserinfoEndpoint
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = doc.JWKSURI
+	}
+
+	p.jwks = keys.NewRemoteJWKS(p.JWKSURL, client)
+	p.discovered = true
+
+	return nil
+}
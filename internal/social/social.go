@@ -0,0 +1,326 @@
+package social
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"music-auth/internal/auth"
+	"music-auth/internal/middleware"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	stateCookieName = "oauth_state"
+	stateCookiePath = "/auth/"
+	stateTTL        = 10 * time.Minute
+)
+
+// Service runs the authorization code + PKCE flow against the configured
+// providers and hands the resulting identity to AuthService.
+type Service struct {
+	Providers map[string]*Provider
+	Client    *http.Client
+
+	auth      *auth.AuthService
+	secret    []byte
+	publicURL string
+	appURL    string
+}
+
+// New wires social login. publicURL is the base URL of this service (where
+// the provider redirects back to) and appURL that of the frontend.
+func New(authService *auth.AuthService, providers map[string]*Provider, secret, publicURL, appURL string) *Service {
+	return &Service{
+		Providers: providers,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		auth:      authService,
+		secret:    []byte(secret),
+		publicURL: strings.TrimRight(publicURL, "/"),
+		appURL:    strings.TrimRight(appURL, "/"),
+	}
+}
+
+// flowState travels in a signed cookie between the login redirect and the
+// callback, so nothing needs to be stored server-side.
+type flowState struct {
+	Provider string    `json:"p"`
+	State    string    `json:"s"`
+	Verifier string    `json:"v"`
+	Nonce    string    `json:"n"`
+	ReturnTo string    `json:"r"`
+	LinkTo   uuid.UUID `json:"l"`
+	Tenant   string    `json:"t"`
+	Expires  int64     `json:"e"`
+}
+
+func (s *Service) redirectURI(provider string) string {
+	return s.publicURL + "/auth/" + provider + "/callback"
+}
+
+// LoginHandler serves GET /auth/{provider}/login?return_to=/path. When the
+// request carries a valid session the identity is linked to that user.
+func (s *Service) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := s.Providers[r.PathValue("provider")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := p.discover(r.Context(), s.Client); err != nil {
+			slog.Error("social login discovery", "provider", p.Name, "error", err)
+			http.Error(w, "login provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		state := flowState{
+			Provider: p.Name,
+			State:    randomString(),
+			Verifier: randomString(),
+			Nonce:    randomString(),
+			ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+			Tenant:   middleware.GetTenant(r.Context()),
+			Expires:  time.Now().Add(stateTTL).Unix(),
+		}
+		if claims, ok := middleware.GetUserFromContext(r.Context()); ok {
+			state.LinkTo = claims.UserID
+		}
+
+		cookie, err := s.sealState(state)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookieName,
+			Value:    cookie,
+			Path:     stateCookiePath,
+			MaxAge:   int(stateTTL.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		challenge := sha256.Sum256([]byte(state.Verifier))
+
+		q := url.Values{}
+		q.Set("response_type", "code")
+		q.Set("client_id", p.ClientID)
+		q.Set("redirect_uri", s.redirectURI(p.Name))
+		q.Set("scope", strings.Join(p.Scopes, " "))
+		q.Set("state", state.State)
+		q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+		q.Set("code_challenge_method", "S256")
+		if p.isOIDC() {
+			q.Set("nonce", state.Nonce)
+		}
+
+		sep := "?"
+		if strings.Contains(p.AuthURL, "?") {
+			sep = "&"
+		}
+
+		http.Redirect(w, r, p.AuthURL+sep+q.Encode(), http.StatusFound)
+	})
+}
+
+// CallbackHandler serves GET /auth/{provider}/callback. It ends by setting
+// the usual session cookies and sending the browser back to the frontend.
+func (s *Service) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := s.Providers[r.PathValue("provider")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: stateCookieName, Path: stateCookiePath, MaxAge: -1})
+
+		state, err := s.readState(r)
+		if err != nil || state.Provider != p.Name ||
+			!hmac.Equal([]byte(state.State), []byte(r.URL.Query().Get("state"))) {
+			s.fail(w, r, "/", "login expired, please try again")
+			return
+		}
+
+		if e := r.URL.Query().Get("error"); e != "" {
+			s.fail(w, r, state.ReturnTo, "login cancelled")
+			return
+		}
+
+		identity, err := s.exchange(r.Context(), p, r.URL.Query().Get("code"), state)
+		if err != nil {
+			slog.Error("social login exchange", "provider", p.Name, "error", err)
+			s.fail(w, r, state.ReturnTo, "could not sign in with "+p.Name)
+			return
+		}
+
+		// The provider redirects to a single callback URL, which may not
+		// resolve to the tenant the login started from.
+		ctx := middleware.WithTenant(r.Context(), state.Tenant)
+
+		tokens, challenge, err := s.auth.LoginWithIdentity(ctx, *identity, state.LinkTo)
+		if err != nil {
+			s.fail(w, r, state.ReturnTo, err.Error())
+			return
+		}
+
+		if challenge != nil {
+			// The challenge token goes in a cookie rather than the URL, where
+			// history, logs and Referer headers would keep it.
+			middleware.SetMFACookie(w, challenge.Token, challenge.ExpiresAt)
+			q := url.Values{"return_to": {state.ReturnTo}}
+			http.Redirect(w, r, s.appURL+"/login/mfa?"+q.Encode(), http.StatusFound)
+			return
+		}
+
+		middleware.SetSessionCookies(w, tokens.AccessToken, tokens.AccessExpiresAt, tokens.RefreshToken, tokens.RefreshExpiresAt)
+		http.Redirect(w, r, s.appURL+state.ReturnTo, http.StatusFound)
+	})
+}
+
+func (s *Service) fail(w http.ResponseWriter, r *http.Request, returnTo, msg string) {
+	q := url.Values{"error": {msg}, "return_to": {returnTo}}
+	http.Redirect(w, r, s.appURL+"/login?"+q.Encode(), http.StatusFound)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+func (s *Service) exchange(ctx context.Context, p *Provider, code string, state *flowState) (*auth.ExternalIdentity, error) {
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.redirectURI(p.Name))
+	form.Set("code_verifier", state.Verifier)
+	if !p.SecretInHeader {
+		form.Set("client_id", p.ClientID)
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.SecretInHeader {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("token response: %w", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token response: %s: %s", token.Error, token.ErrorDesc)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("token response: %s", resp.Status)
+	}
+
+	if p.fetchProfile != nil {
+		return p.fetchProfile(ctx, s.Client, p, token.AccessToken)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("provider returned no id token")
+	}
+
+	identity, err := identityFromIDToken(p, token.IDToken, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		if err := fillFromUserInfo(ctx, s.Client, p, token.AccessToken, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+func (s *Service) sealState(state flowState) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + s.mac(body), nil
+}
+
+func (s *Service) readState(r *http.Request) (*flowState, error) {
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	body, mac, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(body))) {
+		return nil, errors.New("invalid state cookie")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var state flowState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > state.Expires {
+		return nil, errors.New("state expired")
+	}
+
+	return &state, nil
+}
+
+func (s *Service) mac(body string) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte("oauth-state\x00"))
+	h.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeReturnTo only allows paths on the frontend, never another origin.
+func safeReturnTo(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, `\`) {
+		return "/"
+	}
+	return path
+}
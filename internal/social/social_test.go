@@ -0,0 +1,463 @@
+package social
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"music-auth/global/db"
+	"music-auth/internal/auth"
+	"music-auth/internal/jobs"
+	"music-auth/internal/keys"
+	"music-auth/internal/mail"
+	"music-auth/internal/middleware"
+	"music-auth/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	testTenant   = "music-store"
+	testClientID = "music-auth"
+	testSecret   = "client-secret"
+	testAppURL   = "http://app.test"
+)
+
+// fakeIssuer is a minimal OIDC provider. The test plays the browser, so the
+// authorization endpoint is never called: authorize registers a code the way
+// it would.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// grant is what the provider remembers about an authorization code.
+type grant struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{key: key, codes: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                f.URL,
+			AuthorizationEndpoint: f.URL + "/authorize",
+			TokenEndpoint:         f.URL + "/token",
+			JWKSURI:               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keys.JWKS{Keys: []keys.JWK{{
+			Kty: "RSA",
+			Alg: "RS256",
+			Kid: "k1",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", f.token)
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	if r.FormValue("client_id") != testClientID || r.FormValue("client_secret") != testSecret {
+		fail("invalid_client")
+		return
+	}
+
+	f.mu.Lock()
+	g, ok := f.codes[r.FormValue("code")]
+	delete(f.codes, r.FormValue("code"))
+	f.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   f.URL,
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": g.nonce,
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	idToken, err := token.SignedString(f.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: "access", IDToken: idToken, TokenType: "Bearer"})
+}
+
+// authorize stands in for the user approving the login at the provider. It
+// returns the code the provider would send back to the callback.
+func (f *fakeIssuer) authorize(authURL *url.URL, claims jwt.MapClaims) string {
+	q := authURL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code := randomString()
+	f.codes[code] = grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	return code
+}
+
+type testFlow struct {
+	issuer  *fakeIssuer
+	handler http.Handler
+}
+
+func newTestFlow(t *testing.T, authService *auth.AuthService) *testFlow {
+	issuer := newFakeIssuer(t)
+
+	providers := map[string]*Provider{"oidc": {
+		Name:         "oidc",
+		ClientID:     testClientID,
+		ClientSecret: testSecret,
+		Issuer:       issuer.URL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}}
+	s := New(authService, providers, "secret", "http://auth.test", testAppURL)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /auth/{provider}/login", s.LoginHandler())
+	mux.Handle("GET /auth/{provider}/callback", s.CallbackHandler())
+
+	return &testFlow{issuer: issuer, handler: mux}
+}
+
+func (f *testFlow) serve(r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, r.WithContext(middleware.WithTenant(r.Context(), testTenant)))
+	return rec
+}
+
+// login starts a login and returns the provider URL it redirects to and the
+// state cookie it sets.
+func (f *testFlow) login(t *testing.T) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	rec := f.serve(httptest.NewRequest(http.MethodGet, "/auth/oidc/login?return_to=/library", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", rec.Code, rec.Body)
+	}
+
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == stateCookieName {
+			return authURL, c
+		}
+	}
+	t.Fatal("no state cookie")
+	return nil, nil
+}
+
+// callback returns to the callback and reports where the browser is sent
+// next and the cookies set on the way.
+func (f *testFlow) callback(t *testing.T, state *http.Cookie, q url.Values) (*url.URL, map[string]string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+q.Encode(), nil)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	rec := f.serve(req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := map[string]string{}
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	return location, cookies
+}
+
+func TestLoginRedirect(t *testing.T) {
+	f := newTestFlow(t, nil)
+	authURL, _ := f.login(t)
+
+	if got := authURL.Scheme + "://" + authURL.Host + authURL.Path; got != f.issuer.URL+"/authorize" {
+		t.Errorf("redirected to %s", got)
+	}
+
+	q := authURL.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "http://auth.test/auth/oidc/callback",
+		"scope":                 "openid email profile",
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+	for _, k := range []string{"state", "nonce", "code_challenge"} {
+		if q.Get(k) == "" {
+			t.Errorf("missing %s", k)
+		}
+	}
+}
+
+// Callbacks that fail before reaching the user database.
+func TestCallbackRejected(t *testing.T) {
+	claims := jwt.MapClaims{"sub": "u1", "email": "someone@example.com", "email_verified": true}
+
+	tests := []struct {
+		name  string
+		error string
+		// tamper breaks one part of an otherwise valid callback.
+		tamper func(f *testFlow, state **http.Cookie, q url.Values)
+	}{
+		{"state mismatch", "login expired, please try again", func(f *testFlow, state **http.Cookie, q url.Values) {
+			q.Set("state", "forged")
+		}},
+		{"no state cookie", "login expired, please try again", func(f *testFlow, state **http.Cookie, q url.Values) {
+			*state = nil
+		}},
+		{"forged state cookie", "login expired, please try again", func(f *testFlow, state **http.Cookie, q url.Values) {
+			(*state).Value += "x"
+		}},
+		{"wrong PKCE verifier", "could not sign in with oidc", func(f *testFlow, state **http.Cookie, q url.Values) {
+			f.issuer.mu.Lock()
+			defer f.issuer.mu.Unlock()
+			g := f.issuer.codes[q.Get("code")]
+			g.challenge = "not-the-challenge"
+			f.issuer.codes[q.Get("code")] = g
+		}},
+		{"nonce mismatch", "could not sign in with oidc", func(f *testFlow, state **http.Cookie, q url.Values) {
+			f.issuer.mu.Lock()
+			defer f.issuer.mu.Unlock()
+			g := f.issuer.codes[q.Get("code")]
+			g.nonce = "replayed"
+			f.issuer.codes[q.Get("code")] = g
+		}},
+		{"cancelled", "login cancelled", func(f *testFlow, state **http.Cookie, q url.Values) {
+			q.Del("code")
+			q.Set("error", "access_denied")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No AuthService: reaching it would panic.
+			f := newTestFlow(t, nil)
+			authURL, state := f.login(t)
+
+			q := url.Values{
+				"code":  {f.issuer.authorize(authURL, claims)},
+				"state": {authURL.Query().Get("state")},
+			}
+			tt.tamper(f, &state, q)
+
+			location, cookies := f.callback(t, state, q)
+			if location.Path != "/login" || location.Query().Get("error") != tt.error {
+				t.Errorf("redirected to %s", location)
+			}
+			if cookies[middleware.AuthCookieName] != "" {
+				t.Error("session cookie set")
+			}
+		})
+	}
+}
+
+func testAuthService(t *testing.T) (*auth.AuthService, *sql.DB) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := db.MigrateUp(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_SIGNING_ALG", "")
+	keyring, err := keys.InitKeyring("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return auth.New(conn, "secret", keyring, mail.NewMemoryMailer(), testAppURL, ratelimit.NewMemoryStore(), jobs.New(conn)), conn
+}
+
+func TestCallbackLogin(t *testing.T) {
+	authService, conn := testAuthService(t)
+	ctx := context.Background()
+
+	// createUser registers a password user the way an attacker could, with
+	// or without having verified the address.
+	createUser := func(t *testing.T, email string, verified bool) uuid.UUID {
+		var id uuid.UUID
+		err := conn.QueryRowContext(ctx, `
+            INSERT INTO users (tenant_id, username, email, password, email_verified)
+            VALUES ($1, $2, $3, 'password-hash', $4)
+            RETURNING id
+        `, testTenant, "u"+uuid.NewString()[:12], email, verified).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	tests := []struct {
+		name string
+		// existing is whether a password user with the email exists, and
+		// whether its email is verified.
+		existing, existingVerified bool
+		providerVerified           bool
+		error                      string
+	}{
+		{name: "new user", providerVerified: true},
+		{name: "new user, unverified at provider"},
+		{name: "link by email", existing: true, existingVerified: true, providerVerified: true},
+		{name: "local email unverified", existing: true, providerVerified: true, error: auth.ErrEmailNeedsLinking.Error()},
+		{name: "provider email unverified", existing: true, existingVerified: true, error: auth.ErrEmailNeedsLinking.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := "social-" + uuid.NewString()[:8] + "@example.com"
+			subject := uuid.NewString()
+			t.Cleanup(func() {
+				conn.Exec(`DELETE FROM users WHERE tenant_id = $1 AND email = $2`, testTenant, email)
+			})
+
+			var existing uuid.UUID
+			if tt.existing {
+				existing = createUser(t, email, tt.existingVerified)
+			}
+
+			f := newTestFlow(t, authService)
+			authURL, state := f.login(t)
+			code := f.issuer.authorize(authURL, jwt.MapClaims{
+				"sub":            subject,
+				"email":          email,
+				"email_verified": tt.providerVerified,
+			})
+
+			location, cookies := f.callback(t, state, url.Values{"code": {code}, "state": {authURL.Query().Get("state")}})
+
+			var linked uuid.UUID
+			err := conn.QueryRowContext(ctx,
+				`SELECT user_id FROM user_identities WHERE tenant_id = $1 AND provider = 'oidc' AND subject = $2`,
+				testTenant, subject).Scan(&linked)
+
+			if tt.error != "" {
+				if location.Path != "/login" || location.Query().Get("error") != tt.error {
+					t.Errorf("redirected to %s", location)
+				}
+				if cookies[middleware.AuthCookieName] != "" {
+					t.Error("session cookie set")
+				}
+				if err != sql.ErrNoRows {
+					t.Errorf("identity linked: %v", err)
+				}
+
+				var verified bool
+				var password sql.NullString
+				conn.QueryRowContext(ctx, `SELECT email_verified, password FROM users WHERE id = $1`, existing).Scan(&verified, &password)
+				if verified != tt.existingVerified || password.String != "password-hash" {
+					t.Errorf("existing user changed: verified %v, password %q", verified, password.String)
+				}
+				return
+			}
+
+			if got := location.Scheme + "://" + location.Host + location.Path; got != testAppURL+"/library" {
+				t.Errorf("redirected to %s", location)
+			}
+			if cookies[middleware.AuthCookieName] == "" || cookies[middleware.RefreshCookieName] == "" {
+				t.Errorf("session cookies not set: %v", cookies)
+			}
+			if err != nil {
+				t.Fatalf("identity not linked: %v", err)
+			}
+			if tt.existing && linked != existing {
+				t.Errorf("linked to %s, want %s", linked, existing)
+			}
+
+			var (
+				count    int
+				verified bool
+			)
+			err = conn.QueryRowContext(ctx,
+				`SELECT count(*), bool_and(email_verified) FROM users WHERE tenant_id = $1 AND email = $2`,
+				testTenant, email).Scan(&count, &verified)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 || verified != (tt.providerVerified || tt.existingVerified) {
+				t.Errorf("%d users with the email, verified %v", count, verified)
+			}
+
+			// Logging in again finds the linked identity.
+			authURL, state = f.login(t)
+			code = f.issuer.authorize(authURL, jwt.MapClaims{"sub": subject, "email": email, "email_verified": tt.providerVerified})
+			_, cookies = f.callback(t, state, url.Values{"code": {code}, "state": {authURL.Query().Get("state")}})
+			if cookies[middleware.AuthCookieName] == "" {
+				t.Error("second login did not start a session")
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tenant is a storefront sharing this auth service. Its users, albums and
+// tracks are invisible to every other tenant.
+type Tenant struct {
+	ID    string
+	Name  string
+	Hosts []string
+}
+
+// Store resolves tenants from the tenants table. The table is small and
+// rarely changes, so it is kept in memory and reloaded every refresh.
+type Store struct {
+	db      *sql.DB
+	refresh time.Duration
+
+	mu       sync.RWMutex
+	ids      map[string]bool
+	hosts    map[string]string
+	loadedAt time.Time
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, refresh: time.Minute}
+}
+
+// TenantForHost implements middleware.TenantResolver.
+func (s *Store) TenantForHost(ctx context.Context, host string) (string, bool, error) {
+	if err := s.load(ctx); err != nil {
+		return "", false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.hosts[normalizeHost(host)]
+	return id, ok, nil
+}
+
+// TenantExists implements middleware.TenantResolver.
+func (s *Store) TenantExists(ctx context.Context, id string) (bool, error) {
+	if err := s.load(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ids[id], nil
+}
+
+func (s *Store) load(ctx context.Context) error {
+	s.mu.RLock()
+	fresh := time.Since(s.loadedAt) < s.refresh
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	tenants, err := List(ctx, s.db)
+	if err != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		// Keep serving the last good copy if the database hiccups.
+		if s.ids != nil {
+			return nil
+		}
+		return err
+	}
+
+	ids := map[string]bool{}
+	hosts := map[string]string{}
+	for _, t := range tenants {
+		ids[t.ID] = true
+		for _, h := range t.Hosts {
+			hosts[normalizeHost(h)] = t.ID
+		}
+	}
+
+	s.mu.Lock()
+	s.ids, s.hosts, s.loadedAt = ids, hosts, time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Create adds a tenant, for the `tenants create` command.
+func Create(ctx context.Context, db *sql.DB, t Tenant) error {
+	if t.ID == "" || t.Name == "" {
+		return errors.New("id and name are required")
+	}
+
+	for i, h := range t.Hosts {
+		t.Hosts[i] = normalizeHost(h)
+	}
+
+	query := `INSERT INTO tenants (id, name, hosts) VALUES ($1, $2, $3)`
+
+	if _, err := db.ExecContext(ctx, query, t.ID, t.Name, pq.Array(t.Hosts)); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "tenants_pkey" {
+			return fmt.Errorf("tenant %q already exists", t.ID)
+		}
+		return fmt.Errorf("could not create tenant: %w", err)
+	}
+
+	return nil
+}
+
+func List(ctx context.Context, db *sql.DB) ([]*Tenant, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, name, hosts FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []*Tenant
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ID, &t.Name, pq.Array(&t.Hosts)); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, &t)
+	}
+
+	return tenants, rows.Err()
+}
+
+// normalizeHost drops the port and case so "Store.example.com:443" matches
+// "store.example.com".
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"music-auth/internal/keys"
+	"os"
+)
+
+// runKeys implements `music-auth keys generate`, which adds a new signing key
+// to JWT_KEYS_DIR. Running replicas publish it on their next reload and sign
+// with it a few minutes later; older keys keep verifying until they are
+// retired.
+func runKeys(args []string) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return fmt.Errorf("usage: keys generate")
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return fmt.Errorf("JWT_KEYS_DIR is required")
+	}
+
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" || alg == keys.AlgHS256 {
+		return fmt.Errorf("JWT_SIGNING_ALG must be %s or %s", keys.AlgRS256, keys.AlgEdDSA)
+	}
+
+	kid, err := keys.Generate(dir, alg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("generated %s key %s\n", alg, kid)
+	return nil
+}
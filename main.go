@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"music-auth/global/db"
 	"music-auth/graph"
 	"music-auth/internal/auth"
+	"music-auth/internal/idp"
+	"music-auth/internal/jobs"
+	"music-auth/internal/keys"
+	"music-auth/internal/mail"
 	"music-auth/internal/middleware"
-	"music-auth/music/aws"
+	"music-auth/internal/ratelimit"
+	"music-auth/internal/social"
+	"music-auth/internal/tenant"
+	"music-auth/music/cdn"
+	"music-auth/music/ingest"
 	music "music-auth/music/service"
+	"music-auth/music/storage"
+	"music-auth/music/transcode"
 
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/extension"
@@ -29,6 +44,34 @@ func main() {
 		log.Println("⚠️ No .env file found, using system environment variables")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("❌ Migration failed: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			log.Fatalf("❌ Keys command failed: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "clients" {
+		if err := runClients(os.Args[2:]); err != nil {
+			log.Fatalf("❌ Clients command failed: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "tenants" {
+		if err := runTenants(os.Args[2:]); err != nil {
+			log.Fatalf("❌ Tenants command failed: %v", err)
+		}
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
@@ -41,29 +84,119 @@ func main() {
 
 	jwt_secret := os.Getenv("JWT_SECRET")
 
+	// The secret keys more than HS256 tokens: TOTP encryption, emailed
+	// tokens, OAuth state, stream links and local storage URLs.
 	if jwt_secret == "" {
-		fmt.Println("jwt secret is required")
+		log.Fatalf("❌ JWT_SECRET is required")
 	}
 
-	s3Client, uploadManager, bucketName, err := aws.InitAWS()
+	keyring, err := keys.InitKeyring(jwt_secret)
 
 	if err != nil {
-		log.Fatalf("Aws error: %v", err)
+		log.Fatalf("JWT keys error: %v", err)
 	}
 
-	cdn := os.Getenv("AWS_CLOUDFRONT_CDN")
+	go keyring.Run(context.Background(), time.Minute)
+
+	cdnURL := os.Getenv("AWS_CLOUDFRONT_CDN")
 
-	if cdn == "" {
+	if cdnURL == "" {
 		fmt.Println("cdn is required")
 	}
 
-	authService := auth.New(db, jwt_secret)
-	musicService := music.New(db, uploadManager, s3Client, cdn, bucketName)
+	middleware.TrustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+	middleware.DefaultTenant = defaultTenant()
+
+	tenants := tenant.NewStore(db)
+
+	mailer, err := mail.InitMailer()
+
+	if err != nil {
+		log.Fatalf("Mail error: %v", err)
+	}
+
+	appURL := os.Getenv("APP_BASE_URL")
+
+	if appURL == "" {
+		appURL = "http://localhost:" + port
+	}
+
+	publicURL := os.Getenv("PUBLIC_URL")
+
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	store, err := storage.InitStorage(publicURL, jwt_secret)
+
+	if err != nil {
+		log.Fatalf("Storage error: %v", err)
+	}
+
+	limits, err := ratelimit.InitStore(db)
+
+	if err != nil {
+		log.Fatalf("Rate limit error: %v", err)
+	}
+
+	go ratelimit.Run(context.Background(), limits, 10*time.Minute)
+
+	queue := jobs.New(db)
+
+	authService := auth.New(db, jwt_secret, keyring, mailer, appURL, limits, queue)
+
+	providers, err := social.InitProviders()
+
+	if err != nil {
+		log.Fatalf("Social login error: %v", err)
+	}
+
+	socialService := social.New(authService, providers, jwt_secret, publicURL, appURL)
+	issuer := os.Getenv("ISSUER_URL")
+
+	if issuer == "" {
+		issuer = publicURL
+	}
+
+	provider, err := idp.New(db, keyring, issuer, appURL, jwt_secret)
+
+	if err != nil {
+		log.Printf("⚠️ OIDC provider disabled: %v", err)
+	}
+
+	cdnSigner, err := cdn.InitSigner()
+
+	if err != nil {
+		log.Fatalf("CloudFront signer error: %v", err)
+	}
+
+	if cdnSigner == nil {
+		log.Println("⚠️ No CloudFront key configured, playback uses presigned S3 URLs")
+	}
+
+	transcoder, err := transcode.InitTranscoder()
+
+	if err != nil {
+		log.Fatalf("Transcoder error: %v", err)
+	}
+
+	if transcoder == nil {
+		log.Println("⚠️ ffmpeg not found, tracks are served as uploaded")
+	}
+
+	events, err := ingest.InitSource()
+
+	if err != nil {
+		log.Fatalf("S3 events error: %v", err)
+	}
+
+	musicService := music.New(db, store, cdnURL, cdnSigner, transcoder, publicURL, jwt_secret, queue)
 
 	resolver := &graph.Resolver{AuthService: authService, MusicService: musicService}
 
 	srv := handler.New(graph.NewExecutableSchema(graph.Config{
-		Resolvers: resolver,
+		Resolvers:  resolver,
+		Directives: graph.Directives(),
 	}))
 
 	srv.AddTransport(transport.Options{})
@@ -72,18 +205,110 @@ func main() {
 
 	srv.SetQueryCache(lru.New[*ast.QueryDocument](1000))
 
+	srv.Use(graph.CostLimit{MaxCost: 1000, MaxDepth: 10})
 	srv.Use(extension.Introspection{})
 	srv.Use(extension.AutomaticPersistedQuery{
 		Cache: lru.New[string](100),
 	})
 
 	http.Handle("/", playground.Handler("GraphQL playground", "/service"))
+	// Per second, with bursts for page loads that fire several queries.
+	userBuckets := ratelimit.NewTokenBuckets(10, 50)
+	ipBuckets := ratelimit.NewTokenBuckets(5, 30)
+
 	http.Handle("/service",
 		middleware.ResponseWriterMiddleware(
-			middleware.AuthMiddleware([]byte(jwt_secret), srv),
+			middleware.AuthMiddleware(keyring, authService,
+				middleware.RateLimitMiddleware(userBuckets, ipBuckets, srv),
+			),
 		),
 	)
+	http.Handle("/.well-known/jwks.json", keyring.Handler())
+	http.Handle("GET /stream/{trackId}/{path...}", musicService.StreamHandler())
+
+	if local, ok := store.(*storage.Local); ok {
+		http.Handle(storage.LocalPath, local.Handler())
+	}
+
+	if token := os.Getenv("S3_EVENTS_WEBHOOK_TOKEN"); token != "" {
+		http.Handle("POST /hooks/s3-events", ingest.Webhook(token, musicService.IngestEvents))
+	}
+
+	http.Handle("GET /auth/{provider}/login",
+		middleware.ResponseWriterMiddleware(
+			middleware.AuthMiddleware(keyring, authService, socialService.LoginHandler()),
+		),
+	)
+	http.Handle("GET /auth/{provider}/callback",
+		middleware.ResponseWriterMiddleware(socialService.CallbackHandler()),
+	)
+
+	if provider != nil {
+		http.Handle("GET /.well-known/openid-configuration", provider.DiscoveryHandler())
+		http.Handle("/oauth2/authorize",
+			middleware.ResponseWriterMiddleware(
+				middleware.AuthMiddleware(keyring, authService, provider.AuthorizeHandler()),
+			),
+		)
+		http.Handle("POST /oauth2/token", provider.TokenHandler())
+		http.Handle("/oauth2/userinfo", provider.UserInfoHandler())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workers, err := jobWorkers()
+
+	if err != nil {
+		log.Fatalf("Jobs error: %v", err)
+	}
+
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		queue.Run(ctx, workers)
+	}()
+
+	if events != nil {
+		go events.Run(ctx, musicService.IngestEvents)
+	}
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: middleware.TenantMiddleware(tenants, http.DefaultServeMux),
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), jobs.ShutdownGrace)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP shutdown: %v", err)
+		}
+	}()
 
 	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	// Running jobs get to finish, or are handed back to the queue.
+	<-queueDone
+}
+
+// jobWorkers reads JOB_WORKERS, the number of background jobs this instance
+// runs at once.
+func jobWorkers() (int, error) {
+	v := os.Getenv("JOB_WORKERS")
+	if v == "" {
+		return 4, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("JOB_WORKERS must be a positive number")
+	}
+	return n, nil
 }
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Dir stands in for SQS during development and in tests: every .json file
+// put in it is read as a notification, then removed. Files that cannot be
+// parsed are renamed to .failed. Write files elsewhere and move them in, so
+// a half-written file is never read.
+type Dir struct {
+	Path     string
+	Interval time.Duration
+}
+
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("create events dir: %w", err)
+	}
+	return &Dir{Path: path, Interval: time.Second}, nil
+}
+
+func (d *Dir) Run(ctx context.Context, handle Handler) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.poll(ctx, handle); err != nil {
+			slog.Error("read S3 events dir", "dir", d.Path, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dir) poll(ctx context.Context, handle Handler) error {
+	entries, err := os.ReadDir(d.Path)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		file := filepath.Join(d.Path, name)
+
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		events, err := ParseNotification(body)
+		if err != nil {
+			slog.Error("invalid S3 events file", "file", file, "error", err)
+			if err := os.Rename(file, file+".failed"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(events) > 0 {
+			if err := handle(ctx, events); err != nil {
+				// Tried again on the next poll.
+				return err
+			}
+		}
+
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
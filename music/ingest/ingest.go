@@ -0,0 +1,98 @@
+// Package ingest receives S3 ObjectCreated notifications, so uploads can be
+// processed without waiting for the client to report them. Notifications
+// come from an SQS queue the bucket publishes to, from a webhook (MinIO can
+// post them directly), or, for local development and tests, from JSON files
+// dropped into a directory.
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Event is an object created in a bucket.
+type Event struct {
+	Bucket string
+	Key    string
+	Size   int64
+}
+
+// Handler processes a batch of events. An error leaves the notification to
+// be delivered again.
+type Handler func(ctx context.Context, events []Event) error
+
+// Source delivers events to handle until ctx is done.
+type Source interface {
+	Run(ctx context.Context, handle Handler)
+}
+
+// InitSource picks where notifications are read from: the SQS queue at
+// S3_EVENTS_QUEUE_URL, or the directory S3_EVENTS_DIR. It returns nil when
+// neither is set, which leaves the webhook as the only way in.
+func InitSource() (Source, error) {
+	if queueURL := os.Getenv("S3_EVENTS_QUEUE_URL"); queueURL != "" {
+		return newSQSFromEnv(context.Background(), queueURL)
+	}
+
+	if dir := os.Getenv("S3_EVENTS_DIR"); dir != "" {
+		return NewDir(dir)
+	}
+
+	return nil, nil
+}
+
+type notification struct {
+	// Set when the notification was fanned out through SNS without raw
+	// message delivery; Message then holds the S3 notification.
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// ParseNotification reads the ObjectCreated events of an S3 event
+// notification. Other events, and the test event S3 sends when
+// notifications are set up, yield no events.
+func ParseNotification(body []byte) ([]Event, error) {
+	var n notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("invalid S3 notification: %w", err)
+	}
+
+	if n.Type == "Notification" {
+		return ParseNotification([]byte(n.Message))
+	}
+
+	var events []Event
+	for _, r := range n.Records {
+		// "ObjectCreated:Put" from S3, "s3:ObjectCreated:Put" from MinIO.
+		if !strings.Contains(r.EventName, "ObjectCreated:") {
+			continue
+		}
+
+		// Keys are form-encoded, with spaces as "+".
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object key %q: %w", r.S3.Object.Key, err)
+		}
+
+		events = append(events, Event{Bucket: r.S3.Bucket.Name, Key: key, Size: r.S3.Object.Size})
+	}
+
+	return events, nil
+}
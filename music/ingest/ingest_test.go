@@ -0,0 +1,306 @@
+package ingest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+func notificationBody(eventName, bucket, key string) string {
+	return `{"Records":[{"eventName":"` + eventName + `","s3":{"bucket":{"name":"` + bucket +
+		`"},"object":{"key":"` + key + `","size":42}}}]}`
+}
+
+func TestParseNotification(t *testing.T) {
+	raw := notificationBody("ObjectCreated:Put", "music", "tracks/acme/1-ab-My+Song.mp3")
+	sns, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": raw})
+
+	tests := []struct {
+		name string
+		body string
+		want []Event
+	}{
+		{"s3", raw, []Event{{Bucket: "music", Key: "tracks/acme/1-ab-My Song.mp3", Size: 42}}},
+		{"sns", string(sns), []Event{{Bucket: "music", Key: "tracks/acme/1-ab-My Song.mp3", Size: 42}}},
+		{"minio", notificationBody("s3:ObjectCreated:CompleteMultipartUpload", "music", "a"), []Event{{Bucket: "music", Key: "a", Size: 42}}},
+		{"removed", notificationBody("ObjectRemoved:Delete", "music", "a"), nil},
+		{"test event", `{"Service":"Amazon S3","Event":"s3:TestEvent"}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNotification([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := ParseNotification([]byte("not json")); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var handled []Event
+	fail := false
+	handler := Webhook("s3cret", func(ctx context.Context, events []Event) error {
+		if fail {
+			return errors.New("queue down")
+		}
+		handled = append(handled, events...)
+		return nil
+	})
+
+	body := notificationBody("ObjectCreated:Put", "music", "tracks/acme/k")
+
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		fail   bool
+		status int
+		events int
+	}{
+		{"no token", "", body, false, http.StatusUnauthorized, 0},
+		{"wrong token", "Bearer nope", body, false, http.StatusUnauthorized, 0},
+		{"token without scheme", "s3cret", body, false, http.StatusUnauthorized, 0},
+		{"invalid body", "Bearer s3cret", "{", false, http.StatusBadRequest, 0},
+		{"handler fails", "Bearer s3cret", body, true, http.StatusInternalServerError, 0},
+		{"ok", "Bearer s3cret", body, false, http.StatusNoContent, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled, fail = nil, tt.fail
+
+			req := httptest.NewRequest(http.MethodPost, "/hooks/s3-events", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if len(handled) != tt.events {
+				t.Errorf("handled %d events, want %d", len(handled), tt.events)
+			}
+		})
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	d := &Dir{Path: dir, Interval: 10 * time.Millisecond}
+
+	write := func(name, body string) {
+		tmp := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(tmp, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1.json", notificationBody("ObjectCreated:Put", "local", "tracks/acme/k"))
+	write("2.json", "garbage")
+
+	var (
+		mu     sync.Mutex
+		events []Event
+		calls  int
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, func(ctx context.Context, batch []Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			// The first delivery fails and must be retried.
+			if calls++; calls == 1 {
+				return errors.New("try again")
+			}
+			events = append(events, batch...)
+			return nil
+		})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err1 := os.Stat(filepath.Join(dir, "1.json"))
+		_, err2 := os.Stat(filepath.Join(dir, "2.json.failed"))
+		if os.IsNotExist(err1) && err2 == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("files were not processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(events) != 1 || events[0].Key != "tracks/acme/k" || events[0].Bucket != "local" {
+		t.Errorf("events = %v", events)
+	}
+}
+
+// sqsStandIn answers ReceiveMessage with one batch, then long-polls until
+// the client gives up, and records the messages deleted.
+type sqsStandIn struct {
+	t        *testing.T
+	messages []map[string]string
+
+	mu       sync.Mutex
+	received int
+	deleted  []string
+	polled   chan struct{}
+}
+
+func (s *sqsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		s.t.Errorf("unsigned request")
+	}
+
+	var in struct {
+		QueueUrl            string
+		ReceiptHandle       string
+		MaxNumberOfMessages int
+		WaitTimeSeconds     int
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		s.t.Errorf("decode request: %v", err)
+	}
+	if in.QueueUrl != "http://sqs.test/000000000000/uploads" {
+		s.t.Errorf("QueueUrl = %q", in.QueueUrl)
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSQS.ReceiveMessage":
+		if in.MaxNumberOfMessages != 10 || in.WaitTimeSeconds != 20 {
+			s.t.Errorf("receive %d messages waiting %ds", in.MaxNumberOfMessages, in.WaitTimeSeconds)
+		}
+
+		s.mu.Lock()
+		s.received++
+		first := s.received == 1
+		s.mu.Unlock()
+
+		if !first {
+			// The whole first batch has been handled.
+			select {
+			case s.polled <- struct{}{}:
+			default:
+			}
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Messages": s.messages})
+
+	case "AmazonSQS.DeleteMessage":
+		s.mu.Lock()
+		s.deleted = append(s.deleted, in.ReceiptHandle)
+		s.mu.Unlock()
+		w.Write([]byte("{}"))
+
+	default:
+		s.t.Errorf("unexpected action %q", r.Header.Get("X-Amz-Target"))
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestSQS(t *testing.T) {
+	message := func(id, body string) map[string]string {
+		sum := md5.Sum([]byte(body))
+		return map[string]string{"MessageId": id, "ReceiptHandle": "receipt-" + id, "Body": body, "MD5OfBody": hex.EncodeToString(sum[:])}
+	}
+
+	standIn := &sqsStandIn{
+		t: t,
+		messages: []map[string]string{
+			message("ok", notificationBody("ObjectCreated:Put", "music", "tracks/acme/ok")),
+			message("fails", notificationBody("ObjectCreated:Put", "music", "tracks/acme/fails")),
+			message("garbage", "not json"),
+			message("test", `{"Service":"Amazon S3","Event":"s3:TestEvent"}`),
+		},
+		polled: make(chan struct{}),
+	}
+	srv := httptest.NewServer(standIn)
+	defer srv.Close()
+
+	client := sqs.New(sqs.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+	})
+	source := NewSQS(client, "http://sqs.test/000000000000/uploads")
+
+	var (
+		mu      sync.Mutex
+		handled []string
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		source.Run(ctx, func(ctx context.Context, events []Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, e := range events {
+				handled = append(handled, e.Key)
+				if strings.HasSuffix(e.Key, "/fails") {
+					return errors.New("queue down")
+				}
+			}
+			return nil
+		})
+		close(done)
+	}()
+
+	select {
+	case <-standIn.polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first batch was not handled")
+	}
+	cancel()
+	<-done
+
+	if want := []string{"tracks/acme/ok", "tracks/acme/fails"}; !slices.Equal(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+
+	// A failed or unparseable message stays for the redrive policy; one
+	// without upload events is done with.
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+	if want := []string{"receipt-ok", "receipt-test"}; !slices.Equal(standIn.deleted, want) {
+		t.Errorf("deleted %v, want %v", standIn.deleted, want)
+	}
+}
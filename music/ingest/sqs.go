@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	sqsWaitTime = 20 * time.Second
+	sqsMaxBatch = 10
+	// sqsRetryDelay spaces out polls after the queue could not be reached.
+	sqsRetryDelay = 5 * time.Second
+)
+
+// SQS long-polls a queue that the bucket sends its notifications to.
+// Messages are deleted once handled; a failed one becomes visible again
+// after the queue's visibility timeout, and its redrive policy decides when
+// to give up on it.
+type SQS struct {
+	Client   *sqs.Client
+	QueueURL string
+}
+
+func NewSQS(client *sqs.Client, queueURL string) *SQS {
+	return &SQS{Client: client, QueueURL: queueURL}
+}
+
+// newSQSFromEnv uses AWS_REGION and the bucket's static keys if they are
+// set, and the default AWS credential chain otherwise. Requests go to the
+// host of the queue URL, so a local stand-in such as ElasticMQ works too.
+func newSQSFromEnv(ctx context.Context, queueURL string) (*SQS, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3_EVENTS_QUEUE_URL")
+	}
+
+	opts := []func(*config.LoadOptions) error{}
+	accessKey := os.Getenv("AWS_S3_BUCKET_ACCESS_KEY")
+	secretKey := os.Getenv("AWS_S3_BUCKET_SECRET_ACCESS_KEY")
+	if accessKey != "" && secretKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("AWS_REGION is required for S3_EVENTS_QUEUE_URL")
+	}
+
+	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		o.BaseEndpoint = aws.String(u.Scheme + "://" + u.Host)
+	})
+
+	return NewSQS(client, queueURL), nil
+}
+
+func (s *SQS) Run(ctx context.Context, handle Handler) {
+	for ctx.Err() == nil {
+		out, err := s.Client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.QueueURL),
+			MaxNumberOfMessages: sqsMaxBatch,
+			WaitTimeSeconds:     int32(sqsWaitTime / time.Second),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("receive S3 events", "queue", s.QueueURL, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(sqsRetryDelay):
+			}
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			id := aws.ToString(msg.MessageId)
+
+			events, err := ParseNotification([]byte(aws.ToString(msg.Body)))
+			if err != nil {
+				// It will never parse; leave it to the redrive policy.
+				slog.Error("invalid S3 event message", "message_id", id, "error", err)
+				continue
+			}
+
+			if len(events) > 0 {
+				if err := handle(ctx, events); err != nil {
+					slog.Error("handle S3 events", "message_id", id, "error", err)
+					continue
+				}
+			}
+
+			_, err = s.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(s.QueueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil {
+				slog.Error("delete S3 event message", "message_id", id, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package ingest
+
+import (
+	"crypto/subtle"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+const maxNotificationSize = 1 << 20
+
+// Webhook accepts notifications posted with "Authorization: Bearer <token>",
+// as MinIO sends them with an auth token configured. It answers 204 once the
+// events are handled, and 500 if they could not be, so the sender retries.
+func Webhook(token string, handle Handler) http.Handler {
+	want := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNotificationSize))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		events, err := ParseNotification(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(events) > 0 {
+			if err := handle(r.Context(), events); err != nil {
+				slog.Error("handle S3 events", "error", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
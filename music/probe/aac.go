@@ -0,0 +1,61 @@
+package probe
+
+import (
+	"io"
+	"time"
+)
+
+var adtsSampleRates = [16]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// Raw AAC has no index, so the bitrate is averaged over this many frames and
+// the duration estimated from the file size.
+const adtsSampleFrames = 100
+
+func probeADTS(r io.ReaderAt, size int64) (*Info, error) {
+	return probeADTSAt(r, size, 0)
+}
+
+func probeADTSAt(r io.ReaderAt, size, start int64) (*Info, error) {
+	var (
+		info   = &Info{Format: FormatAAC}
+		pos    = start
+		frames int
+		bytes  int64
+	)
+
+	for frames < adtsSampleFrames && pos+7 <= size {
+		h, err := readAt(r, pos, 7)
+		if err != nil {
+			return nil, err
+		}
+		if h[0] != 0xFF || h[1]&0xF6 != 0xF0 {
+			break
+		}
+
+		rate := adtsSampleRates[(h[2]>>2)&0x0F]
+		length := int64(h[3]&0x03)<<11 | int64(h[4])<<3 | int64(h[5]>>5)
+		if rate == 0 || length < 7 {
+			break
+		}
+
+		if frames == 0 {
+			info.SampleRate = rate
+			info.Channels = int(h[2]&0x01)<<2 | int(h[3]>>6)
+		}
+
+		frames++
+		bytes += length
+		pos += length
+	}
+
+	if frames == 0 {
+		return nil, ErrCorrupt
+	}
+
+	// Each frame holds 1024 samples.
+	sampled := samplesToDuration(int64(frames)*1024, info.SampleRate)
+	info.Bitrate = int(float64(bytes*8) / sampled.Seconds())
+	info.Duration = time.Duration(float64(size-start) * 8 / float64(info.Bitrate) * float64(time.Second))
+
+	return info, nil
+}
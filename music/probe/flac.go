@@ -0,0 +1,116 @@
+package probe
+
+import (
+	"io"
+	"strings"
+)
+
+// Vorbis comment fields that map to tags.
+// maxFLACBlocks bounds the metadata blocks read. Encoders write a handful.
+const maxFLACBlocks = 128
+
+var vorbisFields = map[string]string{
+	"TITLE":       "title",
+	"ARTIST":      "artist",
+	"ALBUM":       "album",
+	"GENRE":       "genre",
+	"DATE":        "date",
+	"TRACKNUMBER": "track",
+}
+
+func probeFLAC(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Format: FormatFLAC, Tags: map[string]string{}}
+
+	var (
+		pos        int64 = 4
+		samples    int64
+		streamInfo bool
+	)
+
+	for blocks := 0; ; blocks++ {
+		if blocks == maxFLACBlocks {
+			return nil, ErrCorrupt
+		}
+
+		h, err := readAt(r, pos, 4)
+		if err != nil {
+			return nil, err
+		}
+
+		last := h[0]&0x80 != 0
+		kind := h[0] & 0x7F
+		length := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		pos += 4
+		if pos+length > size {
+			return nil, ErrCorrupt
+		}
+
+		switch kind {
+		case 0: // STREAMINFO
+			b, err := readAt(r, pos, 34)
+			if err != nil {
+				return nil, err
+			}
+			// 20 bits sample rate, 3 bits channels-1, 5 bits bits per
+			// sample-1, 36 bits total samples.
+			info.SampleRate = int(b[10])<<12 | int(b[11])<<4 | int(b[12]>>4)
+			info.Channels = int(b[12]>>1&0x07) + 1
+			samples = int64(b[13]&0x0F)<<32 | int64(be.Uint32(b[14:]))
+			streamInfo = true
+
+		case 4: // VORBIS_COMMENT
+			if length <= 1<<20 {
+				b, err := readAt(r, pos, int(length))
+				if err != nil {
+					return nil, err
+				}
+				readVorbisComments(b, info.Tags)
+			}
+		}
+
+		pos += length
+		if last {
+			break
+		}
+	}
+
+	if !streamInfo || info.SampleRate == 0 {
+		return nil, ErrCorrupt
+	}
+
+	info.Duration = samplesToDuration(samples, info.SampleRate)
+	if info.Duration > 0 {
+		info.Bitrate = int(float64(max(size-pos, 0)*8) / info.Duration.Seconds())
+	}
+
+	return info, nil
+}
+
+// readVorbisComments parses a little-endian vendor string followed by
+// NAME=value pairs.
+func readVorbisComments(b []byte, tags map[string]string) {
+	if len(b) < 4 {
+		return
+	}
+	pos := 4 + int(le.Uint32(b))
+	if pos+4 > len(b) {
+		return
+	}
+
+	count := int(le.Uint32(b[pos:]))
+	pos += 4
+
+	for i := 0; i < count && pos+4 <= len(b); i++ {
+		n := int(le.Uint32(b[pos:]))
+		pos += 4
+		if n < 0 || pos+n > len(b) {
+			return
+		}
+
+		name, value, ok := strings.Cut(string(b[pos:pos+n]), "=")
+		if field, known := vorbisFields[strings.ToUpper(name)]; ok && known {
+			setTag(tags, field, value)
+		}
+		pos += n
+	}
+}
@@ -0,0 +1,182 @@
+package probe
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Frames maps ID3v2.3/2.4 text frames to tag names. v2.2 uses the
+// three-letter ids.
+var id3Frames = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TCON": "genre", "TCO": "genre",
+	"TDRC": "date", "TYER": "date", "TYE": "date",
+	"TRCK": "track", "TRK": "track",
+}
+
+// readID3v2 parses an ID3v2 tag at off if there is one and returns the offset
+// just past it, with off unchanged when there is none. A tag that claims to
+// run past the end of the size bytes is ErrCorrupt.
+func readID3v2(r io.ReaderAt, off, fileSize int64, tags map[string]string) (int64, error) {
+	header, err := readAt(r, off, 10)
+	if err != nil || !bytes.Equal(header[:3], []byte("ID3")) {
+		return off, nil
+	}
+
+	version, flags := header[3], header[5]
+	size := int64(syncsafe(header[6:10]))
+	end := off + 10 + size
+	if flags&0x10 != 0 {
+		// Footer present.
+		end += 10
+	}
+	if end > fileSize {
+		return off, ErrCorrupt
+	}
+
+	// Tags are small; anything past a few MB is embedded artwork we skip.
+	body, err := readAt(r, off+10, int(min(size, 1<<20)))
+	if err != nil {
+		return end, nil
+	}
+	if flags&0x80 != 0 && version < 4 {
+		body = unsynchronise(body)
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// Skip the extended header.
+		n := int(be.Uint32(body))
+		if version >= 4 {
+			n = int(syncsafe(body[:4]))
+		} else {
+			n += 4
+		}
+		pos = n
+	}
+
+	idLen, headLen := 4, 10
+	if version == 2 {
+		idLen, headLen = 3, 6
+	}
+
+	for pos+headLen <= len(body) {
+		id := string(body[pos : pos+idLen])
+		if id[0] == 0 {
+			break
+		}
+
+		var n int
+		switch version {
+		case 2:
+			n = int(body[pos+3])<<16 | int(body[pos+4])<<8 | int(body[pos+5])
+		case 3:
+			n = int(be.Uint32(body[pos+4:]))
+		default:
+			n = int(syncsafe(body[pos+4 : pos+8]))
+		}
+
+		pos += headLen
+		if n <= 0 || pos+n > len(body) {
+			break
+		}
+
+		if name, ok := id3Frames[id]; ok {
+			setTag(tags, name, id3Text(body[pos:pos+n]))
+		}
+		pos += n
+	}
+
+	return end, nil
+}
+
+// id3Text decodes a text frame. Multiple values are joined with "; ".
+func id3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	enc, b := b[0], b[1:]
+
+	var s string
+	switch enc {
+	case 1, 2:
+		s = decodeUTF16(b, enc == 2)
+	case 3:
+		s = string(b)
+	default:
+		s = latin1(b)
+	}
+
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == 0 })
+	return strings.Join(parts, "; ")
+}
+
+// decodeUTF16 honours a byte order mark; without one, the frame's encoding
+// decides (big endian for encoding 2).
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian, b = true, b[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			units = append(units, be.Uint16(b[i:]))
+		} else {
+			units = append(units, le.Uint16(b[i:]))
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// unsynchronise undoes the 0xFF 0x00 escaping of whole-tag unsynchronisation.
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// readID3v1 reads the fixed 128-byte tag at the end of the file, used by
+// older encoders. It reports whether one was found.
+func readID3v1(r io.ReaderAt, size int64, tags map[string]string) bool {
+	if size < 128 {
+		return false
+	}
+
+	b, err := readAt(r, size-128, 128)
+	if err != nil || !bytes.Equal(b[:3], []byte("TAG")) {
+		return false
+	}
+
+	setTag(tags, "title", latin1(b[3:33]))
+	setTag(tags, "artist", latin1(b[33:63]))
+	setTag(tags, "album", latin1(b[63:93]))
+	setTag(tags, "date", latin1(b[93:97]))
+	if b[125] == 0 && b[126] != 0 {
+		setTag(tags, "track", strconv.Itoa(int(b[126])))
+	}
+
+	return true
+}
@@ -0,0 +1,209 @@
+package probe
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// Bitrates in kbit/s by index, for MPEG-1 layers I-III and MPEG-2/2.5
+// layer I and layers II/III.
+var mp3Bitrates = [5][16]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	0: {11025, 12000, 8000},  // MPEG-2.5
+}
+
+// How far past the tag to look for the first frame.
+const mp3SyncWindow = 64 << 10
+
+type mp3Frame struct {
+	version    byte // 3 MPEG-1, 2 MPEG-2, 0 MPEG-2.5
+	layer      int
+	bitrate    int // bit/s
+	sampleRate int
+	channels   int
+	samples    int // per frame
+	length     int // bytes
+}
+
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	var f mp3Frame
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return f, false
+	}
+
+	f.version = (b[1] >> 3) & 3
+	layerBits := (b[1] >> 1) & 3
+	bitrateIdx := b[2] >> 4
+	rateIdx := (b[2] >> 2) & 3
+	padding := int(b[2]>>1) & 1
+
+	rates, ok := mp3SampleRates[f.version]
+	if !ok || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return f, false
+	}
+	f.layer = 4 - int(layerBits)
+	f.sampleRate = rates[rateIdx]
+
+	table := f.layer - 1
+	if f.version != 3 {
+		table = min(3+f.layer-1, 4)
+	}
+	f.bitrate = mp3Bitrates[table][bitrateIdx] * 1000
+
+	f.channels = 2
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+
+	switch {
+	case f.layer == 1:
+		f.samples = 384
+	case f.layer == 3 && f.version != 3:
+		f.samples = 576
+	default:
+		f.samples = 1152
+	}
+
+	if f.layer == 1 {
+		padding *= 4
+	}
+	f.length = f.samples/8*f.bitrate/f.sampleRate + padding
+
+	return f, true
+}
+
+// xingOffset is where a Xing/Info header sits in the first frame, right
+// after the side information.
+func (f mp3Frame) xingOffset() int {
+	switch {
+	case f.version == 3 && f.channels == 1:
+		return 4 + 17
+	case f.version == 3:
+		return 4 + 32
+	case f.channels == 1:
+		return 4 + 9
+	default:
+		return 4 + 17
+	}
+}
+
+func probeMP3(r io.ReaderAt, size int64) (*Info, error) {
+	tags := map[string]string{}
+
+	start, err := readID3v2(r, 0, size, tags)
+	if err != nil {
+		return nil, err
+	}
+	if start >= size {
+		return nil, ErrCorrupt
+	}
+
+	buf, err := readAt(r, start, int(min(mp3SyncWindow, size-start)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Some AAC streams come with an ID3 tag too.
+	if len(buf) >= 2 && buf[0] == 0xFF && buf[1]&0xF6 == 0xF0 {
+		info, err := probeADTSAt(r, size, start)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range info.Tags {
+			setTag(tags, k, v)
+		}
+		info.Tags = tags
+		return info, nil
+	}
+
+	// A frame counts as found when the next one follows where expected, which
+	// rules out stray sync bytes.
+	pos := -1
+	var frame mp3Frame
+	for i := 0; i+4 <= len(buf); i++ {
+		f, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		if next := i + f.length; next+4 <= len(buf) {
+			if g, ok := parseMP3Frame(buf[next:]); !ok || g.sampleRate != f.sampleRate {
+				continue
+			}
+		}
+		pos, frame = i, f
+		break
+	}
+	if pos < 0 {
+		return nil, ErrCorrupt
+	}
+
+	audioStart := start + int64(pos)
+	audioEnd := size
+	if readID3v1(r, size, tags) {
+		audioEnd -= 128
+	}
+
+	info := &Info{
+		Format:     FormatMP3,
+		SampleRate: frame.sampleRate,
+		Channels:   frame.channels,
+		Tags:       tags,
+	}
+
+	first := buf[pos:min(pos+frame.length, len(buf))]
+
+	if frames, n, ok := vbrHeader(first, frame); ok {
+		info.Duration = samplesToDuration(int64(frames)*int64(frame.samples), frame.sampleRate)
+		if n == 0 {
+			n = int(audioEnd - audioStart)
+		}
+		if info.Duration > 0 {
+			info.Bitrate = int(float64(n*8) / info.Duration.Seconds())
+		}
+		return info, nil
+	}
+
+	// Without a VBR header the stream is taken to be constant bitrate.
+	info.Bitrate = frame.bitrate
+	info.Duration = time.Duration(float64(audioEnd-audioStart) * 8 / float64(frame.bitrate) * float64(time.Second))
+
+	return info, nil
+}
+
+// vbrHeader reads the frame count, and the stream size where present, from a
+// Xing/Info or VBRI header in the first frame.
+func vbrHeader(b []byte, f mp3Frame) (frames, size int, ok bool) {
+	if off := f.xingOffset(); off+8 <= len(b) {
+		tag := b[off : off+4]
+		if bytes.Equal(tag, []byte("Xing")) || bytes.Equal(tag, []byte("Info")) {
+			flags := be.Uint32(b[off+4:])
+			pos := off + 8
+			if flags&1 != 0 && pos+4 <= len(b) {
+				frames = int(be.Uint32(b[pos:]))
+				pos += 4
+			}
+			if flags&2 != 0 && pos+4 <= len(b) {
+				size = int(be.Uint32(b[pos:]))
+			}
+			return frames, size, frames > 0
+		}
+	}
+
+	if off := 4 + 32; off+18 <= len(b) && bytes.Equal(b[off:off+4], []byte("VBRI")) {
+		size = int(be.Uint32(b[off+10:]))
+		frames = int(be.Uint32(b[off+14:]))
+		return frames, size, frames > 0
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,250 @@
+package probe
+
+import (
+	"io"
+	"strconv"
+	"time"
+)
+
+// iTunes-style metadata items that map to tags. The © sign is byte 0xA9.
+var mp4Items = map[string]string{
+	"\xa9nam": "title",
+	"\xa9ART": "artist",
+	"\xa9alb": "album",
+	"\xa9gen": "genre",
+	"\xa9day": "date",
+	"trkn":    "track",
+}
+
+// maxMP4Boxes bounds the boxes listed at one level.
+const maxMP4Boxes = 1024
+
+type mp4Box struct {
+	kind       string
+	start, end int64 // of the payload
+}
+
+// mp4Boxes lists the boxes between start and end. Only headers are read, so
+// a large mdat costs nothing to skip.
+func mp4Boxes(r io.ReaderAt, start, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+
+	for pos := start; pos+8 <= end; {
+		if len(boxes) == maxMP4Boxes {
+			return nil, ErrCorrupt
+		}
+
+		h, err := readAt(r, pos, 8)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(be.Uint32(h))
+		header := int64(8)
+
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			ext, err := readAt(r, pos+8, 8)
+			if err != nil {
+				return nil, err
+			}
+			size = int64(be.Uint64(ext))
+			header = 16
+		}
+		if size < header || pos+size > end {
+			return nil, ErrCorrupt
+		}
+
+		boxes = append(boxes, mp4Box{kind: string(h[4:8]), start: pos + header, end: pos + size})
+		pos += size
+	}
+
+	return boxes, nil
+}
+
+func findBox(boxes []mp4Box, kind string) (mp4Box, bool) {
+	for _, b := range boxes {
+		if b.kind == kind {
+			return b, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// boxPath follows nested boxes, e.g. "mdia", "minf", "stbl".
+func boxPath(r io.ReaderAt, parent mp4Box, path ...string) (mp4Box, bool, error) {
+	for _, kind := range path {
+		children, err := mp4Boxes(r, parent.start, parent.end)
+		if err != nil {
+			return mp4Box{}, false, err
+		}
+		var ok bool
+		if parent, ok = findBox(children, kind); !ok {
+			return mp4Box{}, false, nil
+		}
+	}
+	return parent, true, nil
+}
+
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+	top, err := mp4Boxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+
+	moov, ok := findBox(top, "moov")
+	if !ok {
+		return nil, ErrCorrupt
+	}
+
+	info := &Info{Format: FormatM4A, Tags: map[string]string{}}
+
+	mvhd, ok, err := boxPath(r, moov, "mvhd")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrCorrupt
+	}
+	if info.Duration, err = mp4Duration(r, mvhd); err != nil {
+		return nil, err
+	}
+
+	if err := mp4AudioTrack(r, moov, info); err != nil {
+		return nil, err
+	}
+
+	if ilst, ok, err := boxPath(r, moov, "udta", "meta"); err != nil {
+		return nil, err
+	} else if ok {
+		// meta is a full box: skip version and flags.
+		ilst.start += 4
+		if ilst, ok, err = boxPath(r, ilst, "ilst"); err != nil {
+			return nil, err
+		} else if ok {
+			if err := readMP4Items(r, ilst, info.Tags); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if mdat, ok := findBox(top, "mdat"); ok && info.Duration > 0 {
+		info.Bitrate = int(float64((mdat.end-mdat.start)*8) / info.Duration.Seconds())
+	}
+
+	return info, nil
+}
+
+func mp4Duration(r io.ReaderAt, mvhd mp4Box) (time.Duration, error) {
+	b, err := readAt(r, mvhd.start, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	var timescale, duration uint64
+	if b[0] == 1 {
+		timescale = uint64(be.Uint32(b[20:]))
+		duration = be.Uint64(b[24:])
+	} else {
+		timescale = uint64(be.Uint32(b[12:]))
+		duration = uint64(be.Uint32(b[16:]))
+	}
+
+	return samplesToDuration(int64(duration), int(timescale)), nil
+}
+
+// mp4AudioTrack fills sample rate and channels from the sample description
+// of the first sound track.
+func mp4AudioTrack(r io.ReaderAt, moov mp4Box, info *Info) error {
+	children, err := mp4Boxes(r, moov.start, moov.end)
+	if err != nil {
+		return err
+	}
+
+	for _, trak := range children {
+		if trak.kind != "trak" {
+			continue
+		}
+
+		hdlr, ok, err := boxPath(r, trak, "mdia", "hdlr")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		h, err := readAt(r, hdlr.start, 12)
+		if err != nil {
+			return err
+		}
+		if string(h[8:12]) != "soun" {
+			continue
+		}
+
+		stsd, ok, err := boxPath(r, trak, "mdia", "minf", "stbl", "stsd")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrCorrupt
+		}
+
+		// Full box header and entry count, then the first entry: an 8-byte
+		// box header and the audio sample entry fields.
+		b, err := readAt(r, stsd.start, 8+8+28)
+		if err != nil {
+			return err
+		}
+		entry := b[16:]
+		info.Channels = int(be.Uint16(entry[16:]))
+		info.SampleRate = int(be.Uint32(entry[24:]) >> 16)
+
+		return nil
+	}
+
+	return ErrCorrupt
+}
+
+func readMP4Items(r io.ReaderAt, ilst mp4Box, tags map[string]string) error {
+	items, err := mp4Boxes(r, ilst.start, ilst.end)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		name, ok := mp4Items[item.kind]
+		if !ok {
+			continue
+		}
+
+		data, ok, err := boxPath(r, item, "data")
+		if err != nil {
+			return err
+		}
+		if !ok || data.end-data.start < 8 || data.end-data.start > 1<<16 {
+			continue
+		}
+
+		// Type indicator and locale, then the value.
+		b, err := readAt(r, data.start, int(data.end-data.start))
+		if err != nil {
+			return err
+		}
+		value := b[8:]
+
+		if item.kind == "trkn" {
+			// Binary: padding, track number, total.
+			if len(value) >= 4 {
+				if n := be.Uint16(value[2:]); n > 0 {
+					setTag(tags, name, strconv.Itoa(int(n)))
+				}
+			}
+			continue
+		}
+		setTag(tags, name, string(value))
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package probe reads technical metadata and embedded tags from the headers
+// of audio files. It only needs random access to the file, so files in S3
+// can be probed with a few range reads instead of a full download.
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats this package understands, as stored on tracks.
+const (
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+	FormatWAV  = "wav"
+	FormatM4A  = "m4a"
+	FormatAAC  = "aac"
+)
+
+// maxHeaderBytes bounds how much of a file the parsers read. Headers and
+// tags take far less; a file that needs more is broken or crafted to keep
+// the prober busy.
+const maxHeaderBytes = 16 << 20
+
+var (
+	ErrUnsupported = errors.New("unsupported audio format")
+	ErrCorrupt     = errors.New("corrupt audio file")
+)
+
+// Info describes an audio file. Bitrate is in bits per second, averaged over
+// the whole file for variable bitrate encodings. Tags holds the embedded
+// title, artist, album, genre, date and track number where present, under
+// those lowercase names.
+type Info struct {
+	Format     string
+	Duration   time.Duration
+	SampleRate int
+	Channels   int
+	Bitrate    int
+	Tags       map[string]string
+}
+
+// Probe identifies the format of the size bytes in r and parses its headers.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	r = &budgetReader{r: r, left: maxHeaderBytes}
+
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil && !(errors.Is(err, io.EOF) && size >= 12) {
+		if size < 12 {
+			return nil, ErrCorrupt
+		}
+		return nil, err
+	}
+
+	var (
+		info *Info
+		err  error
+	)
+
+	switch {
+	case bytes.Equal(head[:4], []byte("fLaC")):
+		info, err = probeFLAC(r, size)
+	case bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		info, err = probeWAV(r, size)
+	case bytes.Equal(head[4:8], []byte("ftyp")):
+		info, err = probeMP4(r, size)
+	case bytes.Equal(head[:3], []byte("ID3")):
+		// ADTS streams may carry an ID3 tag as well.
+		info, err = probeMP3(r, size)
+	case head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		info, err = probeADTS(r, size)
+	case head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		info, err = probeMP3(r, size)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+	if info.Bitrate == 0 && info.Duration > 0 {
+		info.Bitrate = int(float64(size*8) / info.Duration.Seconds())
+	}
+
+	return info, nil
+}
+
+// readAt reads exactly n bytes at off, reporting a short file as ErrCorrupt.
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	if n < 0 || off < 0 {
+		return nil, ErrCorrupt
+	}
+
+	buf := make([]byte, n)
+	got, err := r.ReadAt(buf, off)
+	if got == n {
+		return buf, nil
+	}
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrCorrupt
+	}
+	return nil, err
+}
+
+// budgetReader fails reads with ErrCorrupt once more than left bytes have
+// been asked for.
+type budgetReader struct {
+	r    io.ReaderAt
+	left int64
+}
+
+func (b *budgetReader) ReadAt(p []byte, off int64) (int, error) {
+	if int64(len(p)) > b.left {
+		return 0, ErrCorrupt
+	}
+	b.left -= int64(len(p))
+	return b.r.ReadAt(p, off)
+}
+
+func samplesToDuration(samples int64, sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+}
+
+// setTag keeps the first non-empty value seen for a tag.
+func setTag(tags map[string]string, name, value string) {
+	if i := strings.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if _, ok := tags[name]; !ok {
+		tags[name] = value
+	}
+}
+
+var be = binary.BigEndian
+var le = binary.LittleEndian
@@ -0,0 +1,206 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func wavFixture() []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+
+	b.WriteString("fmt ")
+	binary.Write(&b, le, uint32(16))
+	binary.Write(&b, le, uint16(1))      // PCM
+	binary.Write(&b, le, uint16(2))      // channels
+	binary.Write(&b, le, uint32(44100))  // sample rate
+	binary.Write(&b, le, uint32(176400)) // byte rate
+	binary.Write(&b, le, uint16(4))      // block align
+	binary.Write(&b, le, uint16(16))     // bits per sample
+
+	b.WriteString("LIST")
+	binary.Write(&b, le, uint32(4+8+5+1))
+	b.WriteString("INFOINAM")
+	binary.Write(&b, le, uint32(5))
+	b.WriteString("Song\x00\x00")
+
+	b.WriteString("data")
+	binary.Write(&b, le, uint32(176400))
+	b.Write(make([]byte, 176400))
+
+	return b.Bytes()
+}
+
+func flacFixture() []byte {
+	var b bytes.Buffer
+	b.WriteString("fLaC")
+
+	// STREAMINFO: 44.1 kHz, 2 channels, 16 bits, 44100 samples.
+	b.Write([]byte{0x00, 0, 0, 34})
+	info := make([]byte, 34)
+	info[10], info[11], info[12] = 0x0A, 0xC4, 0x42
+	info[13] = 0xF0
+	be.PutUint32(info[14:], 44100)
+	b.Write(info)
+
+	var comments bytes.Buffer
+	binary.Write(&comments, le, uint32(1))
+	comments.WriteString("x")
+	binary.Write(&comments, le, uint32(1))
+	binary.Write(&comments, le, uint32(10))
+	comments.WriteString("TITLE=Song")
+
+	b.Write([]byte{0x84, 0, 0, byte(comments.Len())})
+	b.Write(comments.Bytes())
+
+	b.Write(make([]byte, 1000))
+	return b.Bytes()
+}
+
+// mp3Fixture is an ID3v2.3 tag followed by frames of 128 kbit/s, 44.1 kHz
+// stereo MPEG-1 layer III.
+func mp3Fixture(frames int) []byte {
+	var frame bytes.Buffer
+	frame.WriteString("TIT2")
+	binary.Write(&frame, be, uint32(5))
+	frame.Write([]byte{0, 0})
+	frame.WriteString("\x00Song")
+
+	var b bytes.Buffer
+	b.WriteString("ID3\x03\x00\x00")
+	b.Write([]byte{0, 0, 0, byte(frame.Len())})
+	b.Write(frame.Bytes())
+
+	for range frames {
+		f := make([]byte, 417)
+		copy(f, []byte{0xFF, 0xFB, 0x90, 0x00})
+		b.Write(f)
+	}
+
+	return b.Bytes()
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		duration time.Duration
+		rate     int
+		channels int
+	}{
+		{"wav", wavFixture(), FormatWAV, time.Second, 44100, 2},
+		{"flac", flacFixture(), FormatFLAC, time.Second, 44100, 2},
+		{"mp3", mp3Fixture(100), FormatMP3, 2606 * time.Millisecond, 44100, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Format != tt.format || info.SampleRate != tt.rate || info.Channels != tt.channels {
+				t.Errorf("got %s %d Hz %d channels", info.Format, info.SampleRate, info.Channels)
+			}
+			if d := info.Duration - tt.duration; d < -10*time.Millisecond || d > 10*time.Millisecond {
+				t.Errorf("duration = %v, want %v", info.Duration, tt.duration)
+			}
+			if info.Bitrate <= 0 {
+				t.Errorf("bitrate = %d", info.Bitrate)
+			}
+			if info.Tags["title"] != "Song" {
+				t.Errorf("title = %q", info.Tags["title"])
+			}
+		})
+	}
+}
+
+// Every prefix of a valid file either parses or fails cleanly.
+func TestProbeTruncated(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"wav":  wavFixture()[:200],
+		"flac": flacFixture()[:200],
+		"mp3":  mp3Fixture(3),
+	} {
+		for n := range len(data) {
+			_, err := Probe(bytes.NewReader(data[:n]), int64(n))
+			if err != nil && !errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrUnsupported) {
+				t.Errorf("%s cut at %d: %v", name, n, err)
+			}
+		}
+	}
+}
+
+func TestProbeID3PastEOF(t *testing.T) {
+	data := []byte("ID3\x03\x00\x00\x7f\x7f\x7f\x7f\x00\x00\x00\x00\x00\x00")
+
+	_, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("err = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestProbeFLACBlockPastEOF(t *testing.T) {
+	data := flacFixture()[:60]
+	data[42] = 0x04 // VORBIS_COMMENT, not last
+	data[43], data[44], data[45] = 0xFF, 0xFF, 0xFF
+
+	_, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("err = %v, want ErrCorrupt", err)
+	}
+}
+
+// patternReader is a huge file of head followed by body repeated, which
+// counts the bytes read from it.
+type patternReader struct {
+	head, body []byte
+	read       int64
+}
+
+func (p *patternReader) ReadAt(b []byte, off int64) (int, error) {
+	p.read += int64(len(b))
+	for i := range b {
+		pos := off + int64(i)
+		if pos < int64(len(p.head)) {
+			b[i] = p.head[pos]
+		} else {
+			b[i] = p.body[(pos-int64(len(p.head)))%int64(len(p.body))]
+		}
+	}
+	return len(b), nil
+}
+
+// Files of endless tiny blocks must not be walked to the end.
+func TestProbeTinyBlocks(t *testing.T) {
+	const size = 20 << 30
+
+	tests := map[string]*patternReader{
+		"flac": {head: []byte("fLaC"), body: []byte{0x01, 0, 0, 0}},
+		"wav":  {head: []byte("RIFF\x00\x00\x00\x00WAVE"), body: []byte("junk\x00\x00\x00\x00")},
+		"m4a":  {head: []byte("\x00\x00\x00\x0cftypM4A "), body: []byte("\x00\x00\x00\x08free")},
+	}
+
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Probe(r, size)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("err = %v, want ErrCorrupt", err)
+			}
+			if r.read > maxHeaderBytes {
+				t.Errorf("read %d bytes", r.read)
+			}
+		})
+	}
+}
+
+func TestReadAtNegative(t *testing.T) {
+	if _, err := readAt(bytes.NewReader(nil), 0, -1); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("err = %v, want ErrCorrupt", err)
+	}
+}
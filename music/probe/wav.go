@@ -0,0 +1,103 @@
+package probe
+
+import (
+	"io"
+	"time"
+)
+
+// RIFF INFO fields that map to tags.
+// maxRIFFChunks bounds the chunks read before giving up on finding fmt and
+// data.
+const maxRIFFChunks = 256
+
+var riffInfoFields = map[string]string{
+	"INAM": "title",
+	"IART": "artist",
+	"IPRD": "album",
+	"IGNR": "genre",
+	"ICRD": "date",
+	"ITRK": "track",
+}
+
+func probeWAV(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Format: FormatWAV, Tags: map[string]string{}}
+
+	var (
+		pos       int64 = 12
+		byteRate  int64
+		dataBytes int64 = -1
+	)
+
+	for chunks := 0; pos+8 <= size; chunks++ {
+		if chunks == maxRIFFChunks {
+			return nil, ErrCorrupt
+		}
+
+		h, err := readAt(r, pos, 8)
+		if err != nil {
+			return nil, err
+		}
+
+		id := string(h[:4])
+		length := int64(le.Uint32(h[4:]))
+		pos += 8
+
+		switch id {
+		case "fmt ":
+			b, err := readAt(r, pos, 16)
+			if err != nil {
+				return nil, err
+			}
+			info.Channels = int(le.Uint16(b[2:]))
+			info.SampleRate = int(le.Uint32(b[4:]))
+			byteRate = int64(le.Uint32(b[8:]))
+
+		case "data":
+			// Streamed recordings may leave the size unset; the data then
+			// runs to the end of the file.
+			dataBytes = min(length, size-pos)
+			if length == 0 || length == 0xFFFFFFFF {
+				dataBytes = size - pos
+			}
+
+		case "LIST":
+			if length >= 4 && length <= 1<<20 {
+				b, err := readAt(r, pos, int(length))
+				if err != nil {
+					return nil, err
+				}
+				if string(b[:4]) == "INFO" {
+					readRIFFInfo(b[4:], info.Tags)
+				}
+			}
+		}
+
+		// Chunks are padded to an even length.
+		pos += length + length&1
+	}
+
+	if info.SampleRate == 0 || byteRate == 0 || dataBytes < 0 {
+		return nil, ErrCorrupt
+	}
+
+	info.Bitrate = int(byteRate * 8)
+	info.Duration = time.Duration(float64(dataBytes) / float64(byteRate) * float64(time.Second))
+
+	return info, nil
+}
+
+func readRIFFInfo(b []byte, tags map[string]string) {
+	for pos := 0; pos+8 <= len(b); {
+		id := string(b[pos : pos+4])
+		n := int(le.Uint32(b[pos+4:]))
+		pos += 8
+		if n < 0 || pos+n > len(b) {
+			return
+		}
+
+		if name, ok := riffInfoFields[id]; ok {
+			setTag(tags, name, string(b[pos:pos+n]))
+		}
+		pos += n + n&1
+	}
+}
@@ -0,0 +1,418 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"music-auth/graph/model"
+	"music-auth/internal/common"
+	"music-auth/internal/middleware"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrAlbumNotFound = errors.New("album not found")
+
+const albumColumns = `
+        a.id, a.title, a.artist, a.release_date, a.cover_art_key,
+        (SELECT count(*) FROM tracks t WHERE t.album_id = a.id), a.created_at, a.updated_at`
+
+// albumAccess limits a query on albums a to those the caller may manage:
+// their own, or any in the tenant with catalog:manage. It expects
+// accessArgs as $2, $3 and $4.
+const albumAccess = `a.tenant_id = $2 AND (a.user_id = $3 OR $4)`
+
+func accessArgs(claims *common.Claims) []any {
+	return []any{claims.Tenant, claims.UserID, claims.HasPermission("catalog:manage")}
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanAlbum(row rowScanner) (*model.Album, error) {
+	var (
+		a                    model.Album
+		artist, coverArtKey  sql.NullString
+		releaseDate          sql.NullTime
+		createdAt, updatedAt time.Time
+	)
+
+	err := row.Scan(&a.ID, &a.Title, &artist, &releaseDate, &coverArtKey, &a.TrackCount, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if artist.Valid {
+		a.Artist = &artist.String
+	}
+	if releaseDate.Valid {
+		date := releaseDate.Time.Format(time.DateOnly)
+		a.ReleaseDate = &date
+	}
+	if coverArtKey.Valid {
+		a.CoverArtKey = &coverArtKey.String
+	}
+	a.CreatedAt = createdAt.Format(time.RFC3339)
+	a.UpdatedAt = updatedAt.Format(time.RFC3339)
+
+	return &a, nil
+}
+
+func (m *MusicService) getAlbum(ctx context.Context, q queryer, id uuid.UUID) (*model.Album, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `SELECT ` + albumColumns + ` FROM albums a WHERE a.id = $1 AND ` + albumAccess
+
+	album, err := scanAlbum(q.QueryRowContext(ctx, query, append([]any{id}, accessArgs(claims)...)...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAlbumNotFound
+		}
+		return nil, fmt.Errorf("failed to load album: %w", err)
+	}
+
+	return album, nil
+}
+
+// Album returns one of the caller's albums, or any album of the tenant for
+// catalog managers.
+func (m *MusicService) Album(ctx context.Context, id uuid.UUID) (*model.Album, error) {
+	return m.getAlbum(ctx, m.db, id)
+}
+
+// MyAlbums lists the caller's albums, newest first.
+func (m *MusicService) MyAlbums(ctx context.Context) ([]*model.Album, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `
+        SELECT ` + albumColumns + `
+        FROM albums a
+        WHERE a.tenant_id = $1 AND a.user_id = $2
+        ORDER BY a.created_at DESC, a.id DESC
+    `
+
+	rows, err := m.db.QueryContext(ctx, query, claims.Tenant, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load albums: %w", err)
+	}
+	defer rows.Close()
+
+	albums := []*model.Album{}
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load albums: %w", err)
+		}
+		albums = append(albums, album)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load albums: %w", err)
+	}
+
+	return albums, nil
+}
+
+// AlbumTracks lists the tracks of an album in album order. The caller must
+// already have been allowed to see the album.
+func (m *MusicService) AlbumTracks(ctx context.Context, albumID uuid.UUID) ([]*model.Track, error) {
+	query := `
+        SELECT ` + trackColumns + `
+        FROM tracks t
+        WHERE t.album_id = $1 AND t.tenant_id = $2
+        ORDER BY t.album_position, t.created_at
+    `
+
+	rows, err := m.db.QueryContext(ctx, query, albumID, middleware.GetTenant(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tracks: %w", err)
+	}
+	defer rows.Close()
+
+	tracks := []*model.Track{}
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tracks: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+func (m *MusicService) CreateAlbum(ctx context.Context, input model.CreateAlbumInput) (*model.Album, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	title := strings.TrimSpace(input.Title)
+	if title == "" {
+		return nil, fmt.Errorf("title is required")
+	}
+
+	releaseDate, err := parseReleaseDate(input.ReleaseDate)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create album: %w", err)
+	}
+	defer tx.Rollback()
+
+	if input.CoverArtKey != nil {
+		if err := m.claimCoverArt(ctx, tx, claims, *input.CoverArtKey, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+        INSERT INTO albums (tenant_id, user_id, title, artist, release_date, cover_art_key)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `
+
+	var id uuid.UUID
+	err = tx.QueryRowContext(ctx, query, claims.Tenant, claims.UserID, title, nullIfEmptyPtr(input.Artist), releaseDate, nullIfEmptyPtr(input.CoverArtKey)).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create album: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to create album: %w", err)
+	}
+
+	return m.getAlbum(ctx, m.db, id)
+}
+
+func (m *MusicService) UpdateAlbum(ctx context.Context, id uuid.UUID, input model.UpdateAlbumInput) (*model.Album, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	args := append([]any{id}, accessArgs(claims)...)
+	set := []string{"updated_at = now()"}
+
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if title == "" {
+			return nil, fmt.Errorf("title cannot be empty")
+		}
+		args = append(args, title)
+		set = append(set, fmt.Sprintf("title = $%d", len(args)))
+	}
+	// An empty string clears the optional fields.
+	if input.Artist != nil {
+		args = append(args, nullIfEmpty(*input.Artist))
+		set = append(set, fmt.Sprintf("artist = $%d", len(args)))
+	}
+	if input.ReleaseDate != nil {
+		releaseDate, err := parseReleaseDate(input.ReleaseDate)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, releaseDate)
+		set = append(set, fmt.Sprintf("release_date = $%d", len(args)))
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update album: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.lockAlbum(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	if input.CoverArtKey != nil {
+		var current sql.NullString
+		if err := tx.QueryRowContext(ctx, `SELECT cover_art_key FROM albums WHERE id = $1`, id).Scan(&current); err != nil {
+			return nil, fmt.Errorf("failed to update album: %w", err)
+		}
+
+		if err := m.claimCoverArt(ctx, tx, claims, *input.CoverArtKey, current.String); err != nil {
+			return nil, err
+		}
+		// The replaced image belongs to no other album.
+		if current.Valid && current.String != *input.CoverArtKey {
+			if err := m.QueueFileDeletion(ctx, tx, []string{current.String}); err != nil {
+				return nil, fmt.Errorf("failed to update album: %w", err)
+			}
+		}
+
+		args = append(args, nullIfEmpty(*input.CoverArtKey))
+		set = append(set, fmt.Sprintf("cover_art_key = $%d", len(args)))
+	}
+
+	query := `UPDATE albums a SET ` + strings.Join(set, ", ") + ` WHERE a.id = $1 AND ` + albumAccess
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to update album: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update album: %w", err)
+	}
+
+	return m.getAlbum(ctx, m.db, id)
+}
+
+// DeleteAlbum removes the album and keeps its tracks as singles.
+func (m *MusicService) DeleteAlbum(ctx context.Context, id uuid.UUID) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete album: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.lockAlbum(ctx, tx, id); err != nil {
+		return err
+	}
+
+	query := `UPDATE tracks SET album_id = NULL, album_position = NULL, updated_at = now() WHERE album_id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to delete album: %w", err)
+	}
+
+	var coverArtKey sql.NullString
+	if err := tx.QueryRowContext(ctx, `DELETE FROM albums WHERE id = $1 RETURNING cover_art_key`, id).Scan(&coverArtKey); err != nil {
+		return fmt.Errorf("failed to delete album: %w", err)
+	}
+
+	if coverArtKey.Valid {
+		if err := m.QueueFileDeletion(ctx, tx, []string{coverArtKey.String}); err != nil {
+			return fmt.Errorf("failed to delete album: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete album: %w", err)
+	}
+
+	return nil
+}
+
+// ReorderAlbumTracks renumbers the album's tracks in the order of trackIDs,
+// which must name each of them exactly once.
+func (m *MusicService) ReorderAlbumTracks(ctx context.Context, albumID uuid.UUID, trackIDs []uuid.UUID) (*model.Album, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reorder tracks: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.lockAlbum(ctx, tx, albumID); err != nil {
+		return nil, err
+	}
+
+	var current []string
+	query := `SELECT ARRAY(SELECT id::text FROM tracks WHERE album_id = $1)`
+	if err := tx.QueryRowContext(ctx, query, albumID).Scan(pq.Array(&current)); err != nil {
+		return nil, fmt.Errorf("failed to reorder tracks: %w", err)
+	}
+
+	inAlbum := make(map[string]bool, len(current))
+	for _, id := range current {
+		inAlbum[id] = true
+	}
+
+	ids := make([]string, len(trackIDs))
+	for i, id := range trackIDs {
+		ids[i] = id.String()
+		if !inAlbum[ids[i]] {
+			return nil, fmt.Errorf("track %s is not in this album or listed twice", id)
+		}
+		delete(inAlbum, ids[i])
+	}
+	if len(inAlbum) > 0 {
+		return nil, fmt.Errorf("every track of the album must be listed")
+	}
+
+	// tracks_album_position_key is deferred, so positions may collide until
+	// the statement is done.
+	query = `
+        UPDATE tracks t
+        SET album_position = o.position, updated_at = now()
+        FROM unnest($1::uuid[]) WITH ORDINALITY AS o (id, position)
+        WHERE t.id = o.id AND t.album_id = $2
+    `
+	if _, err := tx.ExecContext(ctx, query, pq.Array(ids), albumID); err != nil {
+		return nil, fmt.Errorf("failed to reorder tracks: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE albums SET updated_at = now() WHERE id = $1`, albumID); err != nil {
+		return nil, fmt.Errorf("failed to reorder tracks: %w", err)
+	}
+
+	album, err := m.getAlbum(ctx, tx, albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to reorder tracks: %w", err)
+	}
+
+	return album, nil
+}
+
+// lockAlbum checks that the caller may manage the album and locks it for
+// the rest of the transaction, serializing changes to its track order.
+func (m *MusicService) lockAlbum(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	claims := middleware.CurrentUser(ctx)
+
+	var found uuid.UUID
+	query := `SELECT a.id FROM albums a WHERE a.id = $1 AND ` + albumAccess + ` FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, append([]any{id}, accessArgs(claims)...)...).Scan(&found)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrAlbumNotFound
+		}
+		return fmt.Errorf("failed to load album: %w", err)
+	}
+
+	return nil
+}
+
+func parseReleaseDate(date *string) (*time.Time, error) {
+	if date == nil || *date == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, *date)
+	if err != nil {
+		return nil, fmt.Errorf("release date must look like 2024-01-31")
+	}
+	return &t, nil
+}
+
+// claimCoverArt accepts an image the caller uploaded, claiming it like a
+// track's upload so no other album can take it. An empty key, or the album's
+// current one, is left as it is.
+func (m *MusicService) claimCoverArt(ctx context.Context, tx *sql.Tx, claims *common.Claims, key, current string) error {
+	if key == "" || key == current {
+		return nil
+	}
+
+	_, err := m.claimUpload(ctx, tx, claims.Tenant, claims.UserID, key, "image/")
+	return err
+}
+
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullIfEmptyPtr(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return nullIfEmpty(*s)
+}
@@ -0,0 +1,160 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"music-auth/graph/model"
+	"music-auth/internal/jobs"
+	"music-auth/internal/middleware"
+	"music-auth/music/ingest"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	kindIngestUpload = "uploads.ingest"
+	// ingestDelay gives a client that calls saveTrack right after uploading
+	// the chance to save the track with its own details first.
+	ingestDelay     = time.Minute
+	ingestAttempts  = 3
+	ingestTimeout   = 5 * time.Minute
+	ingestKeyPrefix = "ingest:"
+)
+
+type ingestPayload struct {
+	Key string `json:"key"`
+}
+
+// IngestEvents queues the processing of uploads S3 reports as created, so
+// they become tracks even if the client never calls saveTrack. Objects of
+// other buckets, and the files the server stores itself, are skipped.
+func (m *MusicService) IngestEvents(ctx context.Context, events []ingest.Event) error {
+	for _, e := range events {
+		if e.Bucket != m.Storage.Bucket() || !isUploadKey(e.Key) {
+			continue
+		}
+
+		err := m.jobs.Enqueue(ctx, nil, kindIngestUpload, ingestPayload{Key: e.Key},
+			jobs.After(ingestDelay), jobs.MaxAttempts(ingestAttempts), jobs.Unique(ingestKeyPrefix+e.Key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isUploadKey matches keys from newUploadKey. Renditions and streams live
+// further down, under the upload's key.
+func isUploadKey(key string) bool {
+	return strings.HasPrefix(key, "tracks/") && strings.Count(key, "/") == 2
+}
+
+// ingestUpload saves a pending audio upload as a track of the user it was
+// issued to, with details from the file. Uploads saved by then, images for
+// cover art and unknown keys are left alone.
+func (m *MusicService) ingestUpload(ctx context.Context, job *jobs.Job) error {
+	var payload ingestPayload
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	query := `
+        SELECT tenant_id, user_id, content_type, upload_id IS NOT NULL AND completed_at IS NULL
+        FROM pending_uploads
+        WHERE key = $1 AND expires_at > now()
+    `
+
+	var (
+		tenant, contentType string
+		userID              uuid.UUID
+		incomplete          bool
+	)
+	err := m.db.QueryRowContext(ctx, query, payload.Key).Scan(&tenant, &userID, &contentType, &incomplete)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(contentType, "audio/") {
+		return nil
+	}
+
+	// S3 reports a multipart upload as soon as it is assembled, which may
+	// be before completeMultipartUpload has recorded that.
+	if incomplete {
+		return fmt.Errorf("multipart upload of %s is not completed yet", payload.Key)
+	}
+
+	err = m.saveTrack(ctx, tenant, userID, nil, "", "", "", payload.Key, 0, true)
+	if err == ErrUnknownUpload {
+		// Saved by the client in the meantime.
+		return nil
+	}
+	if err != nil {
+		slog.Warn("ingest upload", "key", payload.Key, "attempt", job.Attempt, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// completeAutoSavedTrack applies the details of a saveTrack call to a track
+// that was already saved from its S3 event. That works once per track, like
+// saving an upload.
+func (m *MusicService) completeAutoSavedTrack(ctx context.Context, albumID *uuid.UUID, title, artist, genre, key string, fileSize int64) error {
+	claims := middleware.CurrentUser(ctx)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE tracks SET auto_saved = false
+        WHERE key = $1 AND tenant_id = $2 AND user_id = $3 AND auto_saved
+        RETURNING id, file_size
+    `
+
+	var (
+		id   uuid.UUID
+		size int64
+	)
+	err = tx.QueryRowContext(ctx, query, key, claims.Tenant, claims.UserID).Scan(&id, &size)
+	if err == sql.ErrNoRows {
+		return ErrUnknownUpload
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+
+	if fileSize != 0 && fileSize != size {
+		return fmt.Errorf("fileSize does not match the uploaded file")
+	}
+
+	// Empty values keep what was read from the file.
+	input := model.UpdateTrackInput{Title: &title, AlbumID: albumID}
+	if artist != "" {
+		input.Artist = &artist
+	}
+	if genre != "" {
+		input.Genre = &genre
+	}
+
+	if _, err := m.updateTrack(ctx, tx, id, input); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package music
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"music-auth/global/db"
+	"music-auth/internal/jobs"
+	"music-auth/music/ingest"
+	"music-auth/music/storage"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIsUploadKey(t *testing.T) {
+	tests := map[string]bool{
+		"tracks/acme/1700000000000-ab12cd34-song.mp3":              true,
+		"tracks/acme/1700000000000-ab12cd34-song.mp3/hls/a.m3u8":   false,
+		"tracks/acme/1700000000000-ab12cd34-song.mp3/renditions/x": false,
+		"tracks/acme":       false,
+		"covers/acme/x.png": false,
+		"tracks/a/b/c":      false,
+		"":                  false,
+	}
+
+	for key, want := range tests {
+		if got := isUploadKey(key); got != want {
+			t.Errorf("isUploadKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+// testDB connects to the database at TEST_DATABASE_URL and migrates it.
+// Tests that need one are skipped without it.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := db.MigrateUp(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// wavFile is one second of 8 kHz mono silence.
+func wavFile() []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, []any{uint32(16), uint16(1), uint16(1), uint32(8000), uint32(8000), uint16(1), uint16(8)})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(8000))
+	b.Write(make([]byte, 8000))
+	return b.Bytes()
+}
+
+// An upload reported twice, and its job run twice, becomes one track.
+func TestIngestUploadIsIdempotent(t *testing.T) {
+	conn := testDB(t)
+	ctx := context.Background()
+
+	store, err := storage.NewLocal(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(conn, store, "", nil, nil, "http://localhost", "secret", jobs.New(conn))
+
+	const tenant = "music-store"
+	name := "ingest-" + uuid.NewString()[:8]
+
+	var userID uuid.UUID
+	err = conn.QueryRowContext(ctx,
+		`INSERT INTO users (tenant_id, username, email, password) VALUES ($1, $2, $3, 'x') RETURNING id`,
+		tenant, name, name+"@example.com").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := newUploadKey(tenant, "Field Recording.wav")
+	t.Cleanup(func() {
+		conn.Exec(`DELETE FROM jobs WHERE unique_key = $1`, ingestKeyPrefix+key)
+		conn.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+
+	if err := store.Put(ctx, key, bytes.NewReader(wavFile()), "audio/wav"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ExecContext(ctx, `
+        INSERT INTO pending_uploads (key, tenant_id, user_id, content_type, expires_at)
+        VALUES ($1, $2, $3, 'audio/wav', now() + interval '1 hour')
+    `, key, tenant, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same notification, delivered twice through the directory source.
+	dir := t.TempDir()
+	body := fmt.Sprintf(`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":%q},"object":{"key":%q}}}]}`,
+		store.Bucket(), url.QueryEscape(key))
+	for _, file := range []string{"1.json", "2.json"} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		(&ingest.Dir{Path: dir, Interval: 10 * time.Millisecond}).Run(runCtx, m.IngestEvents)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if entries, _ := os.ReadDir(dir); len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("events were not processed")
+		}
+	}
+	cancel()
+	<-done
+
+	var queued int
+	err = conn.QueryRowContext(ctx, `SELECT count(*) FROM jobs WHERE unique_key = $1`, ingestKeyPrefix+key).Scan(&queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != 1 {
+		t.Fatalf("%d ingest jobs queued, want 1", queued)
+	}
+
+	payload, _ := json.Marshal(ingestPayload{Key: key})
+	job := &jobs.Job{Kind: kindIngestUpload, Payload: payload, Attempt: 1, MaxAttempts: ingestAttempts}
+	for range 2 {
+		if err := m.ingestUpload(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		tracks    int
+		autoSaved bool
+		title     string
+	)
+	err = conn.QueryRowContext(ctx,
+		`SELECT count(*), bool_and(auto_saved), min(title) FROM tracks WHERE key = $1`, key).Scan(&tracks, &autoSaved, &title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracks != 1 || !autoSaved || title != "Field Recording" {
+		t.Errorf("got %d tracks, auto_saved %v, title %q", tracks, autoSaved, title)
+	}
+}
@@ -0,0 +1,304 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"music-auth/internal/jobs"
+	"music-auth/internal/middleware"
+	"music-auth/music/storage"
+	"strings"
+	"time"
+)
+
+const (
+	// multipartUploadTTL is how long a multipart upload may take before the
+	// sweeper aborts it.
+	multipartUploadTTL = 7 * 24 * time.Hour
+	partURLTTL         = time.Hour
+	// multipartPartSize is the part size suggested to clients. S3 needs at
+	// least 5 MB per part except the last, and at most 10000 parts.
+	multipartPartSize      = 16 << 20
+	minPartSize            = 5 << 20
+	maxPartNumber          = 10000
+	maxPartsPerRequest     = 100
+	maxMultipartUploadSize = 20 << 30
+)
+
+var ErrUnknownMultipartUpload = errors.New("unknown or expired multipart upload")
+
+// MultipartUpload is an upload in parts, for files too large or connections
+// too flaky for a single PUT.
+type MultipartUpload struct {
+	UploadID  string
+	Key       string
+	PartSize  int64
+	ExpiresAt time.Time
+}
+
+// UploadPart is a presigned PUT for one part.
+type UploadPart struct {
+	PartNumber int32
+	URL        string
+	ExpiresAt  time.Time
+}
+
+// InitiateMultipartUpload starts a multipart upload of an audio file under a
+// new key and records it as a pending upload of the caller. Parts are
+// uploaded with URLs from PresignUploadParts, and the key can be saved as a
+// track after CompleteMultipartUpload.
+func (m *MusicService) InitiateMultipartUpload(ctx context.Context, filename, contentType string) (*MultipartUpload, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	if !strings.HasPrefix(contentType, "audio/") {
+		return nil, fmt.Errorf("only audio files can be uploaded in parts")
+	}
+
+	key := newUploadKey(claims.Tenant, filename)
+
+	uploadID, err := m.Storage.CreateMultipartUpload(ctx, key, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	expiresAt := time.Now().Add(multipartUploadTTL)
+
+	query := `
+        INSERT INTO pending_uploads (key, tenant_id, user_id, content_type, expires_at, upload_id)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+
+	_, err = m.db.ExecContext(ctx, query, key, claims.Tenant, claims.UserID, contentType, expiresAt, uploadID)
+	if err != nil {
+		m.abortInStorage(ctx, key, uploadID)
+		return nil, fmt.Errorf("failed to record upload: %w", err)
+	}
+
+	return &MultipartUpload{UploadID: uploadID, Key: key, PartSize: multipartPartSize, ExpiresAt: expiresAt}, nil
+}
+
+// multipartKey returns the key of an incomplete multipart upload of the
+// caller.
+func (m *MusicService) multipartKey(ctx context.Context, uploadID string) (string, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `
+        SELECT key FROM pending_uploads
+        WHERE upload_id = $1 AND tenant_id = $2 AND user_id = $3
+          AND completed_at IS NULL AND expires_at > now()
+    `
+
+	var key string
+	err := m.db.QueryRowContext(ctx, query, uploadID, claims.Tenant, claims.UserID).Scan(&key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrUnknownMultipartUpload
+		}
+		return "", fmt.Errorf("failed to load upload: %w", err)
+	}
+
+	return key, nil
+}
+
+// PresignUploadParts returns a PUT URL for each part number. A part can be
+// uploaded again, e.g. after a dropped connection, with a fresh URL.
+func (m *MusicService) PresignUploadParts(ctx context.Context, uploadID string, partNumbers []int32) ([]*UploadPart, error) {
+	if len(partNumbers) == 0 || len(partNumbers) > maxPartsPerRequest {
+		return nil, fmt.Errorf("between 1 and %d part numbers can be presigned at once", maxPartsPerRequest)
+	}
+	for _, n := range partNumbers {
+		if n < 1 || n > maxPartNumber {
+			return nil, fmt.Errorf("part numbers must be between 1 and %d", maxPartNumber)
+		}
+	}
+
+	key, err := m.multipartKey(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(partURLTTL)
+	parts := make([]*UploadPart, 0, len(partNumbers))
+
+	for _, n := range partNumbers {
+		url, err := m.Storage.PresignUploadPart(ctx, key, uploadID, n, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign part %d: %w", n, err)
+		}
+
+		parts = append(parts, &UploadPart{PartNumber: n, URL: url, ExpiresAt: expiresAt})
+	}
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object. The
+// parts are listed from storage, so clients need not collect ETags. Afterwards the
+// key can be saved with saveTrack like a single upload.
+func (m *MusicService) CompleteMultipartUpload(ctx context.Context, uploadID string) error {
+	key, err := m.multipartKey(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	parts, err := m.Storage.ListParts(ctx, key, uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to list parts: %w", err)
+	}
+
+	if len(parts) == 0 {
+		return fmt.Errorf("no parts have been uploaded")
+	}
+	for _, p := range parts[:len(parts)-1] {
+		if p.Size < minPartSize {
+			return fmt.Errorf("every part except the last must be at least %d MB", minPartSize>>20)
+		}
+	}
+
+	if err := m.Storage.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		return fmt.Errorf("failed to complete upload: %w", err)
+	}
+
+	// The track can be saved within the usual window from now on.
+	query := `
+        UPDATE pending_uploads
+        SET completed_at = now(), expires_at = greatest(expires_at, $2)
+        WHERE upload_id = $1
+    `
+	if _, err := m.db.ExecContext(ctx, query, uploadID, time.Now().Add(pendingUploadTTL)); err != nil {
+		return fmt.Errorf("failed to record upload: %w", err)
+	}
+
+	return nil
+}
+
+// AbortMultipartUpload discards an incomplete multipart upload and the parts
+// uploaded so far.
+func (m *MusicService) AbortMultipartUpload(ctx context.Context, uploadID string) error {
+	claims := middleware.CurrentUser(ctx)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to abort upload: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        DELETE FROM pending_uploads
+        WHERE upload_id = $1 AND tenant_id = $2 AND user_id = $3 AND completed_at IS NULL
+        RETURNING key
+    `
+
+	var key string
+	err = tx.QueryRowContext(ctx, query, uploadID, claims.Tenant, claims.UserID).Scan(&key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUnknownMultipartUpload
+		}
+		return fmt.Errorf("failed to abort upload: %w", err)
+	}
+
+	// The row is only dropped once storage has let go of the parts, so a
+	// failed abort can be retried.
+	err = m.Storage.AbortMultipartUpload(ctx, key, uploadID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to abort upload: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to abort upload: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MusicService) abortInStorage(ctx context.Context, key, uploadID string) {
+	err := m.Storage.AbortMultipartUpload(ctx, key, uploadID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.Error("abort multipart upload", "key", key, "upload_id", uploadID, "error", err)
+	}
+}
+
+const kindSweepUploads = "uploads.sweep"
+
+// sweepUploads is the hourly job that clears out expired pending uploads,
+// aborting multipart uploads that were never completed. Files uploaded but
+// never saved as a track or cover art are deleted.
+func (m *MusicService) sweepUploads(ctx context.Context, _ *jobs.Job) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        DELETE FROM pending_uploads
+        WHERE expires_at < now()
+        RETURNING key, upload_id, completed_at IS NULL
+    `
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	type stale struct{ key, uploadID string }
+	var (
+		aborts  []stale
+		unsaved []string
+	)
+
+	for rows.Next() {
+		var (
+			key        string
+			uploadID   sql.NullString
+			incomplete bool
+		)
+		if err := rows.Scan(&key, &uploadID, &incomplete); err != nil {
+			rows.Close()
+			return err
+		}
+		if uploadID.Valid && incomplete {
+			aborts = append(aborts, stale{key, uploadID.String})
+		} else {
+			unsaved = append(unsaved, key)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := m.QueueFileDeletion(ctx, tx, unsaved); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, u := range aborts {
+		m.abortInStorage(ctx, u.key, u.uploadID)
+	}
+
+	// Uploads storage still holds past their deadline, e.g. from a failed abort
+	// or a crash before the row was written.
+	cutoff := time.Now().Add(-multipartUploadTTL)
+
+	uploads, err := m.Storage.ListMultipartUploads(ctx, "tracks/")
+	if err != nil {
+		return err
+	}
+	for _, u := range uploads {
+		if u.Initiated.Before(cutoff) {
+			m.abortInStorage(ctx, u.Key, u.UploadID)
+		}
+	}
+
+	return nil
+}
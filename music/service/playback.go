@@ -0,0 +1,113 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"music-auth/internal/middleware"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// playbackTTL is how long a playback link stays valid. Players keep issuing
+// range requests while a track plays, so it has to outlast a long track.
+const playbackTTL = 30 * time.Minute
+
+var (
+	ErrNotEntitled              = errors.New("an active subscription is required to play this track")
+	ErrSignedCookiesUnavailable = errors.New("signed cookies are not available, request a signed URL instead")
+)
+
+// Playback is a time-limited way to stream one track. With Cookies set, URL
+// is unsigned and the cookies carry the signature.
+type Playback struct {
+	URL       string
+	ExpiresAt time.Time
+	Cookies   []*http.Cookie
+}
+
+// PlaybackURL grants the caller temporary access to a track of their
+// tenant: to the ready rendition named by preset, or to the upload itself if
+// preset is empty. Owners and catalog managers can always play; everyone
+// else needs a paid subscription that has not ended.
+func (m *MusicService) PlaybackURL(ctx context.Context, trackID uuid.UUID, preset string, cookies bool) (*Playback, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `
+        SELECT t.key, r.key,
+               t.user_id = $3 OR $4 OR (
+                   u.subscription_type <> 'free'
+                   AND (u.ending_subscription_date IS NULL OR u.ending_subscription_date >= current_date)
+               )
+        FROM tracks t
+        JOIN users u ON u.id = $3 AND u.tenant_id = t.tenant_id
+        LEFT JOIN track_renditions r ON r.track_id = t.id AND r.preset = $5 AND r.status = 'ready' AND NOT r.hls
+        WHERE t.id = $1 AND t.tenant_id = $2
+    `
+
+	var (
+		key          string
+		renditionKey sql.NullString
+		entitled     bool
+	)
+	args := append(append([]any{trackID}, accessArgs(claims)...), preset)
+	err := m.db.QueryRowContext(ctx, query, args...).Scan(&key, &renditionKey, &entitled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTrackNotFound
+		}
+		return nil, fmt.Errorf("failed to load track: %w", err)
+	}
+	if !entitled {
+		return nil, ErrNotEntitled
+	}
+	if preset != "" {
+		if !renditionKey.Valid {
+			return nil, ErrRenditionNotReady
+		}
+		key = renditionKey.String
+	}
+
+	expires := time.Now().Add(playbackTTL)
+
+	if m.CDNSigner == nil {
+		if cookies {
+			return nil, ErrSignedCookiesUnavailable
+		}
+		return m.presignedPlayback(ctx, key, expires)
+	}
+
+	objectURL := m.CDN + (&url.URL{Path: "/" + key}).EscapedPath()
+
+	if cookies {
+		if !m.CDNSigner.CookiesEnabled() {
+			return nil, ErrSignedCookiesUnavailable
+		}
+		// The wildcard also covers files derived from the object that share
+		// its key as a prefix.
+		signed, err := m.CDNSigner.SignedCookies(objectURL+"*", expires)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign playback cookies: %w", err)
+		}
+		return &Playback{URL: objectURL, ExpiresAt: expires, Cookies: signed}, nil
+	}
+
+	signed, err := m.CDNSigner.SignURL(objectURL, expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign playback URL: %w", err)
+	}
+	return &Playback{URL: signed, ExpiresAt: expires}, nil
+}
+
+func (m *MusicService) presignedPlayback(ctx context.Context, key string, expires time.Time) (*Playback, error) {
+	url, err := m.Storage.PresignGet(ctx, key, expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign playback URL: %w", err)
+	}
+
+	return &Playback{URL: url, ExpiresAt: expires}, nil
+}
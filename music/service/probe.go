@@ -0,0 +1,111 @@
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"music-auth/music/probe"
+	"music-auth/music/storage"
+	"time"
+)
+
+const (
+	// probeChunk is the unit of range reads; most headers fit in one or two.
+	probeChunk = 64 << 10
+	// probeMaxChunks bounds the chunks kept in memory; the parsers only
+	// revisit the last few.
+	probeMaxChunks = 64
+	probeTimeout   = 30 * time.Second
+)
+
+// probeUpload reads duration, format and the other technical metadata of an
+// uploaded file from its headers, without downloading the whole file.
+func (m *MusicService) probeUpload(ctx context.Context, key string, size int64) (*probe.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	r := &rangeReader{ctx: ctx, storage: m.Storage, key: key, size: size, chunks: map[int64][]byte{}}
+
+	info, err := probe.Probe(r, size)
+	if errors.Is(err, probe.ErrUnsupported) || errors.Is(err, probe.ErrCorrupt) {
+		return nil, fmt.Errorf("the uploaded file is not a supported audio file (MP3, FLAC, WAV, AAC or M4A)")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+
+	return info, nil
+}
+
+// rangeReader serves ReadAt from ranged reads of a stored object. Reads are
+// rounded to whole chunks, which are kept up to probeMaxChunks, so parsers
+// can read small pieces without a request each.
+type rangeReader struct {
+	ctx     context.Context
+	storage storage.Storage
+	key     string
+	size    int64
+	chunks  map[int64][]byte
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	if len(r.chunks) >= probeMaxChunks {
+		clear(r.chunks)
+	}
+
+	end := min(off+int64(len(p)), r.size)
+	if err := r.fetch(off/probeChunk, (end-1)/probeChunk); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for pos := off; pos < end; {
+		chunk := r.chunks[pos/probeChunk]
+		c := copy(p[n:], chunk[pos%probeChunk:])
+		n += c
+		pos += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch loads the missing chunks between first and last with one request.
+func (r *rangeReader) fetch(first, last int64) error {
+	for first <= last && r.chunks[first] != nil {
+		first++
+	}
+	for last >= first && r.chunks[last] != nil {
+		last--
+	}
+	if first > last {
+		return nil
+	}
+
+	start, end := first*probeChunk, min((last+1)*probeChunk, r.size)
+
+	body, err := r.storage.Get(r.ctx, r.key, start, end-start)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	buf := make([]byte, end-start)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return err
+	}
+
+	for i := first; i <= last; i++ {
+		from := (i - first) * probeChunk
+		r.chunks[i] = buf[from:min(from+probeChunk, int64(len(buf)))]
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package music
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"music-auth/music/storage"
+	"testing"
+)
+
+func TestRangeReaderBoundsCache(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 3*probeMaxChunks*probeChunk+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := store.Put(context.Background(), "tracks/t/k", bytes.NewReader(data), "audio/wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &rangeReader{ctx: context.Background(), storage: store, key: "tracks/t/k", size: int64(len(data)), chunks: map[int64][]byte{}}
+
+	buf := make([]byte, 4000)
+	for off := int64(0); off < int64(len(data)); off += int64(len(buf)) {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], data[off:off+int64(n)]) {
+			t.Fatalf("wrong bytes at %d", off)
+		}
+		if len(r.chunks) > probeMaxChunks+1 {
+			t.Fatalf("%d chunks cached", len(r.chunks))
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"music-auth/graph/model"
+	"music-auth/internal/jobs"
+	"music-auth/internal/middleware"
+	"music-auth/music/transcode"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxTranscodeAttempts = 3
+	// transcodeTimeout bounds one track, all presets included.
+	transcodeTimeout = 30 * time.Minute
+)
+
+var ErrRenditionNotReady = errors.New("rendition is not available for this track")
+
+// renditionKey places renditions under the upload's key, so they share its
+// tenant prefix and signed CDN cookies. For HLS variants it is the media
+// playlist.
+func renditionKey(key string, p transcode.Preset) string {
+	if p.HLS {
+		return hlsPrefix(key) + p.Name + "/index.m3u8"
+	}
+	return key + "/renditions/" + p.Name + p.Ext
+}
+
+// enqueueRenditions queues every preset and HLS variant for a new track.
+// Without a transcoder tracks are only served as uploaded.
+func (m *MusicService) enqueueRenditions(ctx context.Context, tx *sql.Tx, trackID uuid.UUID) error {
+	if m.Transcoder == nil {
+		return nil
+	}
+
+	query := `INSERT INTO track_renditions (track_id, preset, codec, bitrate, hls) VALUES ($1, $2, $3, $4, $5)`
+
+	for _, p := range append(transcode.Presets, transcode.HLSVariants...) {
+		if _, err := tx.ExecContext(ctx, query, trackID, p.Name, p.Codec, p.Bitrate, p.HLS); err != nil {
+			return err
+		}
+	}
+
+	return m.jobs.Enqueue(ctx, tx, kindTranscode, transcodePayload{TrackID: trackID},
+		jobs.MaxAttempts(maxTranscodeAttempts), jobs.Unique("transcode:"+trackID.String()))
+}
+
+// TrackRenditions lists the file renditions of a track the caller can
+// already see, lowest bitrate first per codec. HLS variants are only played
+// through StreamManifest.
+func (m *MusicService) TrackRenditions(ctx context.Context, trackID uuid.UUID) ([]*model.Rendition, error) {
+	query := `
+        SELECT r.preset, r.codec, r.bitrate, r.status, r.file_size
+        FROM track_renditions r
+        JOIN tracks t ON t.id = r.track_id
+        WHERE r.track_id = $1 AND t.tenant_id = $2 AND NOT r.hls
+        ORDER BY r.codec, r.bitrate
+    `
+
+	rows, err := m.db.QueryContext(ctx, query, trackID, middleware.GetTenant(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load renditions: %w", err)
+	}
+	defer rows.Close()
+
+	renditions := []*model.Rendition{}
+	for rows.Next() {
+		var (
+			r             model.Rendition
+			codec, status string
+			fileSize      sql.NullInt64
+		)
+		if err := rows.Scan(&r.Preset, &codec, &r.Bitrate, &status, &fileSize); err != nil {
+			return nil, fmt.Errorf("failed to load renditions: %w", err)
+		}
+		r.Codec = model.AudioCodec(strings.ToUpper(codec))
+		r.Status = model.RenditionStatus(strings.ToUpper(status))
+		if fileSize.Valid {
+			r.FileSize = &fileSize.Int64
+		}
+		renditions = append(renditions, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load renditions: %w", err)
+	}
+
+	return renditions, nil
+}
+
+// SelectRendition picks the ready rendition with the highest bitrate up to
+// maxBitrate (0 for no limit), preferring codecs in the given order. With no
+// codecs any codec will do.
+func SelectRendition(renditions []*model.Rendition, codecs []model.AudioCodec, maxBitrate int32) *model.Rendition {
+	rank := func(c model.AudioCodec) int {
+		if len(codecs) == 0 {
+			return 0
+		}
+		return slices.Index(codecs, c)
+	}
+
+	var best *model.Rendition
+	for _, r := range renditions {
+		if r.Status != model.RenditionStatusReady || rank(r.Codec) < 0 {
+			continue
+		}
+		if maxBitrate > 0 && r.Bitrate > maxBitrate {
+			continue
+		}
+		if best == nil || rank(r.Codec) < rank(best.Codec) ||
+			(rank(r.Codec) == rank(best.Codec) && r.Bitrate > best.Bitrate) {
+			best = r
+		}
+	}
+
+	return best
+}
+
+const kindTranscode = "track.transcode"
+
+type transcodePayload struct {
+	TrackID uuid.UUID `json:"track_id"`
+}
+
+type claimedRendition struct {
+	id     uuid.UUID
+	preset transcode.Preset
+}
+
+// transcodeTrack is the job that produces the renditions of a track that are
+// not ready yet, downloading the upload once. Any failed rendition fails the
+// job, whose retries then redo just the failed ones.
+func (m *MusicService) transcodeTrack(ctx context.Context, job *jobs.Job) error {
+	var payload transcodePayload
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	trackID := payload.TrackID
+
+	query := `
+        UPDATE track_renditions r
+        SET status = 'processing', updated_at = now()
+        FROM tracks t
+        WHERE t.id = r.track_id AND r.track_id = $1 AND r.status <> 'ready'
+        RETURNING r.id, r.preset, t.key
+    `
+
+	rows, err := m.db.QueryContext(ctx, query, trackID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		claimed []claimedRendition
+		key     string
+	)
+	for rows.Next() {
+		var (
+			c    claimedRendition
+			name string
+		)
+		if err := rows.Scan(&c.id, &name, &key); err != nil {
+			rows.Close()
+			return err
+		}
+		p, ok := transcode.PresetByName(name)
+		if !ok {
+			// Presets dropped from Presets can no longer be produced.
+			m.failRendition(ctx, c, fmt.Errorf("unknown preset %q", name))
+			continue
+		}
+		c.preset = p
+		claimed = append(claimed, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Everything is ready, unless the track was deleted. A retry after the
+	// master playlist failed to write only has that left to do.
+	if len(claimed) == 0 {
+		err := m.db.QueryRowContext(ctx, `SELECT key FROM tracks WHERE id = $1`, trackID).Scan(&key)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return m.writeMasterPlaylist(ctx, trackID, key)
+	}
+
+	dir, err := os.MkdirTemp("", "transcode-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "source"+path.Ext(key))
+	if err := m.download(ctx, key, src); err != nil {
+		for _, c := range claimed {
+			m.failRendition(ctx, c, err)
+		}
+		return err
+	}
+
+	var (
+		failed int
+		hls    bool
+	)
+	for _, c := range claimed {
+		if err := m.produceRendition(ctx, trackID, key, src, dir, c); err != nil {
+			slog.Error("transcode rendition", "track_id", trackID, "preset", c.preset.Name, "error", err)
+			m.failRendition(ctx, c, err)
+			failed++
+			continue
+		}
+		hls = hls || c.preset.HLS
+	}
+
+	if hls {
+		if err := m.writeMasterPlaylist(ctx, trackID, key); err != nil {
+			return fmt.Errorf("write master playlist: %w", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d renditions failed", failed, len(claimed))
+	}
+	return nil
+}
+
+func (m *MusicService) produceRendition(ctx context.Context, trackID uuid.UUID, key, src, dir string, c claimedRendition) error {
+	outKey := renditionKey(key, c.preset)
+
+	var (
+		size int64
+		err  error
+	)
+	if c.preset.HLS {
+		size, err = m.packageHLS(ctx, key, src, dir, c.preset)
+	} else {
+		dst := filepath.Join(dir, c.preset.Name+c.preset.Ext)
+		if err := m.Transcoder.Transcode(ctx, src, dst, c.preset); err != nil {
+			return err
+		}
+		size, err = m.uploadFile(ctx, dst, outKey, c.preset.ContentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE track_renditions
+        SET status = 'ready', key = $2, file_size = $3, error = NULL, updated_at = now()
+        WHERE id = $1
+    `
+	res, err := m.db.ExecContext(ctx, query, c.id, outKey, size)
+	if err != nil {
+		return err
+	}
+
+	// The track was deleted while it was being transcoded.
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if err := m.QueueFileDeletion(ctx, nil, []string{key}); err != nil {
+			slog.Error("delete renditions of deleted track", "track_id", trackID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// uploadFile stores a local file and returns its size.
+func (m *MusicService) uploadFile(ctx context.Context, file, key, contentType string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.Storage.Put(ctx, key, f, contentType); err != nil {
+		return 0, fmt.Errorf("upload %s: %w", key, err)
+	}
+
+	return stat.Size(), nil
+}
+
+// failRendition records why a rendition failed. The job decides whether it
+// is tried again.
+func (m *MusicService) failRendition(ctx context.Context, c claimedRendition, cause error) {
+	query := `UPDATE track_renditions SET status = 'failed', error = $2, updated_at = now() WHERE id = $1`
+
+	// Not bound to the job's timeout, which may be what ran out.
+	ctx = context.WithoutCancel(ctx)
+
+	if _, err := m.db.ExecContext(ctx, query, c.id, cause.Error()); err != nil {
+		slog.Error("record transcode failure", "rendition_id", c.id, "error", err)
+	}
+}
+
+// download copies an object to a local file.
+func (m *MusicService) download(ctx context.Context, key, dst string) error {
+	body, err := m.Storage.Get(ctx, key, 0, -1)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	defer body.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+
+	return f.Close()
+}
@@ -1,113 +1,289 @@
 package music
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"music-auth/internal/jobs"
 	"music-auth/internal/middleware"
+	"music-auth/music/cdn"
+	"music-auth/music/storage"
+	"music-auth/music/transcode"
+	"path"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/google/uuid"
-
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type MusicService struct {
-	db         *sql.DB
-	S3Uploader *manager.Uploader
-	S3Client   *s3.Client
-	S3Bucket   string
-	Presigner  *s3.PresignClient
-	CDN        string
+	db *sql.DB
+	// Storage holds uploads and everything derived from them.
+	Storage storage.Storage
+	CDN     string
+	// CDNSigner signs playback URLs for CDN. Without it playback falls back
+	// to presigned storage URLs.
+	CDNSigner *cdn.Signer
+	// Transcoder produces the renditions of new tracks. Without it tracks
+	// are only served as uploaded.
+	Transcoder transcode.Transcoder
+	// PublicURL is the base URL of this server, for stream links.
+	PublicURL    string
+	streamSecret []byte
+	jobs         *jobs.Queue
 }
 
-func New(db *sql.DB, uploader *manager.Uploader, client *s3.Client, cdn, bucket string) *MusicService {
-	return &MusicService{
-		db:         db,
-		S3Uploader: uploader,
-		S3Client:   client,
-		S3Bucket:   bucket,
-		Presigner:  s3.NewPresignClient(client),
-		CDN:        cdn,
+// New wires the music service. publicURL is where StreamHandler is reachable,
+// and secret keys the signatures of stream links. The service registers its
+// background work (transcoding, ingestion, file cleanup, the upload sweeper)
+// on queue.
+func New(db *sql.DB, store storage.Storage, cdnURL string, signer *cdn.Signer, transcoder transcode.Transcoder, publicURL, secret string, queue *jobs.Queue) *MusicService {
+	m := &MusicService{
+		db:           db,
+		Storage:      store,
+		CDN:          strings.TrimRight(cdnURL, "/"),
+		CDNSigner:    signer,
+		Transcoder:   transcoder,
+		PublicURL:    strings.TrimRight(publicURL, "/"),
+		streamSecret: []byte(secret),
+		jobs:         queue,
 	}
-}
 
-func (m *MusicService) CreatePresignedForPUTRequest(fileName, contentType, key string) (string, error) {
-	req, err := m.Presigner.PresignPutObject(
-		context.TODO(),
-		&s3.PutObjectInput{
-			Bucket:      aws.String(m.S3Bucket),
-			Key:         aws.String(key),
-			ContentType: aws.String(contentType),
-		},
-		func(opts *s3.PresignOptions) {
-			opts.Expires = 15 * time.Minute
-		},
-	)
-	if err != nil {
-		return "", err
+	// Instances without ffmpeg leave transcoding to those with it.
+	if transcoder != nil {
+		queue.Register(kindTranscode, transcodeTimeout, m.transcodeTrack)
 	}
-	return req.URL, nil
+	queue.Register(kindIngestUpload, ingestTimeout, m.ingestUpload)
+	queue.Register(kindDeleteFiles, 5*time.Minute, m.deleteFiles)
+	queue.Register(kindSweepUploads, 10*time.Minute, m.sweepUploads)
+	queue.Schedule(kindSweepUploads, time.Hour)
+
+	return m
 }
 
-func (m *MusicService) GetPresignedURLForTrackUploading(ctx context.Context, filename, contentType string) (string, string, error) {
+// GetPresignedURLForTrackUploading hands out an upload URL for a new key and
+// records the key as a pending upload of the caller, which saveTrack
+// requires. A declared fileSize is signed into the URL, so storage refuses any
+// other size.
+func (m *MusicService) GetPresignedURLForTrackUploading(ctx context.Context, filename, contentType string, fileSize *int64) (*Upload, error) {
 
-	_, ok := middleware.GetUserFromContext(ctx)
-
-	if !ok {
-		return "", "", fmt.Errorf("unauthorized")
-	}
+	claims := middleware.CurrentUser(ctx)
 
 	if filename == "" {
-		return "", "", fmt.Errorf("filename is required")
+		return nil, fmt.Errorf("filename is required")
 	}
 	if contentType == "" {
-		return "", "", fmt.Errorf("content-type is required")
+		return nil, fmt.Errorf("content-type is required")
+	}
+	if !allowedUploadType(contentType) {
+		return nil, fmt.Errorf("only audio files and cover images can be uploaded")
+	}
+	if fileSize != nil && (*fileSize <= 0 || *fileSize > maxUploadSize) {
+		return nil, fmt.Errorf("file size must be between 1 byte and %d MB", maxUploadSize>>20)
 	}
 
-	key := fmt.Sprintf("tracks/%d-%s", time.Now().UnixMilli(), filename)
+	key := newUploadKey(claims.Tenant, filename)
 
-	url, err := m.CreatePresignedForPUTRequest(filename, contentType, key)
+	url, err := m.Storage.PresignPut(ctx, key, contentType, fileSize, time.Now().Add(uploadURLTTL))
 
 	if err != nil {
-		return "", "", fmt.Errorf("%s", err.Error())
+		return nil, fmt.Errorf("%s", err.Error())
 	}
 
-	return url, key, nil
+	query := `
+        INSERT INTO pending_uploads (key, tenant_id, user_id, content_type, file_size, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+
+	_, err = m.db.ExecContext(ctx, query, key, claims.Tenant, claims.UserID, contentType, fileSize, time.Now().Add(pendingUploadTTL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record upload: %w", err)
+	}
+
+	return &Upload{URL: url, Key: key, ExpiresAt: time.Now().Add(uploadURLTTL)}, nil
+
+}
+
+// SaveTrackInDB stores a track for an upload of the caller. The file must be
+// stored and match the pending upload; its size is taken from storage, and a
+// fileSize from the client is only checked against it. Duration, format and
+// the other audio details come from probing the file, whose tags also fill
+// in a missing artist or genre. If the upload was already saved from its S3
+// event, the track is completed with the details given here instead.
+func (m *MusicService) SaveTrackInDB(ctx context.Context, albumID *uuid.UUID, title, artist, genre, key string, fileSize int64) (error) {
+	claims := middleware.CurrentUser(ctx)
 
+	// Uploads of other storefronts live under their own prefix.
+	if !strings.HasPrefix(key, tenantKeyPrefix(claims.Tenant)) {
+		return fmt.Errorf("invalid key")
+	}
+
+	err := m.saveTrack(ctx, claims.Tenant, claims.UserID, albumID, title, artist, genre, key, fileSize, false)
+	if err == ErrUnknownUpload {
+		return m.completeAutoSavedTrack(ctx, albumID, title, artist, genre, key, fileSize)
+	}
+	return err
 }
 
-func (m *MusicService) SaveTrackInDB(ctx context.Context, albumID *uuid.UUID, title, artist, genre, format, key string, duration, fileSize int32) (error) {
-	claims, ok := middleware.GetUserFromContext(ctx)
+// saveTrack stores a track for an upload of the user. autoSaved marks
+// tracks saved without the client, whose title may come from the file.
+func (m *MusicService) saveTrack(ctx context.Context, tenant string, userID uuid.UUID, albumID *uuid.UUID, title, artist, genre, key string, fileSize int64, autoSaved bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+	defer tx.Rollback()
 
-	if !ok {
-		return fmt.Errorf("unauthorized")
+	size, err := m.claimUpload(ctx, tx, tenant, userID, key, "audio/")
+	if err != nil {
+		return err
+	}
+	if fileSize != 0 && fileSize != size {
+		return fmt.Errorf("fileSize does not match the uploaded file")
 	}
 
-	userID := claims.UserID
+	info, err := m.probeUpload(ctx, key, size)
+	if err != nil {
+		return err
+	}
+	if title == "" {
+		title = cmp.Or(info.Tags["title"], uploadFilename(key), "Untitled")
+	}
+	if artist == "" {
+		artist = info.Tags["artist"]
+	}
+	if genre == "" {
+		genre = info.Tags["genre"]
+	}
+
+	tags, err := json.Marshal(info.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+
+	// Locking the album keeps concurrent uploads from taking the same
+	// position.
+	if albumID != nil {
+		var found uuid.UUID
+		query := `SELECT id FROM albums WHERE id = $1 AND user_id = $2 AND tenant_id = $3 FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, albumID, userID, tenant).Scan(&found)
+		if err == sql.ErrNoRows {
+			return ErrAlbumNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save track: %w", err)
+		}
+	}
 
 	query := `
-        INSERT INTO tracks (user_id, album_id, title, artist, genre, duration, file_size, format, key, cdn_url)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        INSERT INTO tracks (tenant_id, user_id, album_id, album_position, title, artist, genre, duration, file_size, format,
+                            sample_rate, channels, bitrate, tags, key, cdn_url, auto_saved)
+        VALUES ($1, $2, $3::uuid,
+                CASE WHEN $3::uuid IS NOT NULL THEN
+                    (SELECT COALESCE(max(album_position), 0) + 1 FROM tracks WHERE album_id = $3::uuid)
+                END,
+                $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+        RETURNING id
     `
 
-	_, err := m.db.Exec(query,
+	var trackID uuid.UUID
+	err = tx.QueryRowContext(ctx, query,
+		tenant,
 		userID,
 		albumID,
 		title,
-		artist,
-		genre,
-		duration,
-		fileSize,
-		format,
+		nullIfEmpty(artist),
+		nullIfEmpty(genre),
+		int32(info.Duration.Round(time.Second)/time.Second),
+		size,
+		info.Format,
+		info.SampleRate,
+		info.Channels,
+		info.Bitrate,
+		tags,
 		key,
 		m.CDN+"/"+key,
-	)
+		autoSaved,
+	).Scan(&trackID)
 	if err != nil {
 		return fmt.Errorf("failed to save track: %w", err)
 	}
 
+	if err := m.enqueueRenditions(ctx, tx, trackID); err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save track: %w", err)
+	}
+
 	return nil
 }
+
+func tenantKeyPrefix(tenant string) string {
+	return "tracks/" + tenant + "/"
+}
+
+func newUploadKey(tenant, filename string) string {
+	return fmt.Sprintf("%s%d-%s-%s", tenantKeyPrefix(tenant), time.Now().UnixMilli(), uuid.NewString()[:8], path.Base(filename))
+}
+
+// uploadFilename recovers the file name, without extension, that a key from
+// newUploadKey was made for.
+func uploadFilename(key string) string {
+	name := path.Base(key)
+	if parts := strings.SplitN(name, "-", 3); len(parts) == 3 {
+		name = parts[2]
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+const kindDeleteFiles = "files.delete"
+
+type deleteFilesPayload struct {
+	Keys []string `json:"keys"`
+}
+
+// QueueFileDeletion deletes the files of tracks, renditions included, in the
+// background and with retries. With tx the deletion only happens if tx
+// commits.
+func (m *MusicService) QueueFileDeletion(ctx context.Context, tx *sql.Tx, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var ex jobs.Execer
+	if tx != nil {
+		ex = tx
+	}
+
+	return m.jobs.Enqueue(ctx, ex, kindDeleteFiles, deleteFilesPayload{Keys: keys})
+}
+
+func (m *MusicService) deleteFiles(ctx context.Context, job *jobs.Job) error {
+	var payload deleteFilesPayload
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	return m.DeleteTrackFiles(ctx, payload.Keys)
+}
+
+// DeleteTrackFiles removes the uploaded files of tracks along with
+// everything derived from them under their keys, such as renditions.
+func (m *MusicService) DeleteTrackFiles(ctx context.Context, keys []string) error {
+	all := slices.Clone(keys)
+
+	for _, key := range keys {
+		derived, err := m.Storage.List(ctx, key+"/")
+		if err != nil {
+			return err
+		}
+		all = append(all, derived...)
+	}
+
+	return m.Storage.Delete(ctx, all)
+}
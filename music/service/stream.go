@@ -0,0 +1,339 @@
+package music
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"music-auth/internal/middleware"
+	"music-auth/music/transcode"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// streamTTL is how long a stream link and the segment URLs it hands out stay
+// valid. VOD playlists are loaded once, so it bounds how long a listener can
+// keep playing.
+const streamTTL = 2 * time.Hour
+
+var ErrStreamNotReady = errors.New("streaming is not ready for this track yet")
+
+func hlsPrefix(key string) string {
+	return key + "/hls/"
+}
+
+// packageHLS writes one HLS variant and uploads its playlist and segments
+// under the variant's directory. It returns the total size.
+func (m *MusicService) packageHLS(ctx context.Context, key, src, dir string, p transcode.Preset) (int64, error) {
+	out := filepath.Join(dir, p.Name)
+	if err := os.Mkdir(out, 0o700); err != nil {
+		return 0, err
+	}
+	if err := m.Transcoder.PackageHLS(ctx, src, out, p); err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		contentType := "audio/mp4"
+		if strings.HasSuffix(e.Name(), ".m3u8") {
+			contentType = "application/vnd.apple.mpegurl"
+		}
+
+		size, err := m.uploadFile(ctx, filepath.Join(out, e.Name()), hlsPrefix(key)+p.Name+"/"+e.Name(), contentType)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	return total, nil
+}
+
+type hlsVariant struct {
+	preset  string
+	bitrate int
+}
+
+func (m *MusicService) readyVariants(ctx context.Context, trackID uuid.UUID) ([]hlsVariant, error) {
+	query := `
+        SELECT preset, bitrate FROM track_renditions
+        WHERE track_id = $1 AND hls AND status = 'ready'
+        ORDER BY bitrate
+    `
+
+	rows, err := m.db.QueryContext(ctx, query, trackID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []hlsVariant
+	for rows.Next() {
+		var v hlsVariant
+		if err := rows.Scan(&v.preset, &v.bitrate); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+
+	return variants, rows.Err()
+}
+
+// masterPlaylist lists the variants, each media playlist URI followed by
+// query.
+func masterPlaylist(variants []hlsVariant, query string) string {
+	var b strings.Builder
+
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, v := range variants {
+		// BANDWIDTH is the peak; allow for container overhead.
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"mp4a.40.2\"\n",
+			v.bitrate*1100, v.bitrate*1000)
+		fmt.Fprintf(&b, "%s/index.m3u8%s\n", v.preset, query)
+	}
+
+	return b.String()
+}
+
+// writeMasterPlaylist stores the master playlist of the ready variants next
+// to them, for players that fetch it from the CDN with signed cookies.
+func (m *MusicService) writeMasterPlaylist(ctx context.Context, trackID uuid.UUID, key string) error {
+	variants, err := m.readyVariants(ctx, trackID)
+	if err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+
+	return m.Storage.Put(ctx, hlsPrefix(key)+"master.m3u8", strings.NewReader(masterPlaylist(variants, "")), "application/vnd.apple.mpegurl")
+}
+
+// StreamManifest returns the master playlist of a track's adaptive stream,
+// with the same entitlement rules as PlaybackURL.
+//
+// Players resolve segment URIs against the playlist and drop its query
+// string, so a signed playlist URL alone does not reach the segments. With
+// cookies, CloudFront cookies cover the whole stream and the stored master
+// playlist is used. Otherwise the URL points at StreamHandler, which serves
+// the playlists with every segment URL signed.
+func (m *MusicService) StreamManifest(ctx context.Context, trackID uuid.UUID, cookies bool) (*Playback, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `
+        SELECT t.key,
+               t.user_id = $3 OR $4 OR (
+                   u.subscription_type <> 'free'
+                   AND (u.ending_subscription_date IS NULL OR u.ending_subscription_date >= current_date)
+               ),
+               EXISTS (SELECT 1 FROM track_renditions r WHERE r.track_id = t.id AND r.hls AND r.status = 'ready')
+        FROM tracks t
+        JOIN users u ON u.id = $3 AND u.tenant_id = t.tenant_id
+        WHERE t.id = $1 AND t.tenant_id = $2
+    `
+
+	var (
+		key             string
+		entitled, ready bool
+	)
+	err := m.db.QueryRowContext(ctx, query, append([]any{trackID}, accessArgs(claims)...)...).Scan(&key, &entitled, &ready)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTrackNotFound
+		}
+		return nil, fmt.Errorf("failed to load track: %w", err)
+	}
+	if !entitled {
+		return nil, ErrNotEntitled
+	}
+	if !ready {
+		return nil, ErrStreamNotReady
+	}
+
+	expires := time.Now().Add(streamTTL)
+
+	if cookies {
+		if m.CDNSigner == nil || !m.CDNSigner.CookiesEnabled() {
+			return nil, ErrSignedCookiesUnavailable
+		}
+
+		prefix := m.CDN + (&url.URL{Path: "/" + hlsPrefix(key)}).EscapedPath()
+		signed, err := m.CDNSigner.SignedCookies(prefix+"*", expires)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign stream cookies: %w", err)
+		}
+		return &Playback{URL: prefix + "master.m3u8", ExpiresAt: expires, Cookies: signed}, nil
+	}
+
+	u := fmt.Sprintf("%s/stream/%s/master.m3u8?%s", m.PublicURL, trackID, m.streamQuery(trackID, expires).Encode())
+	return &Playback{URL: u, ExpiresAt: expires}, nil
+}
+
+// streamQuery signs access to the playlists of one track until expires.
+func (m *MusicService) streamQuery(trackID uuid.UUID, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{"expires": {exp}, "sig": {m.streamSignature(trackID, exp)}}
+}
+
+func (m *MusicService) streamSignature(trackID uuid.UUID, expires string) string {
+	mac := hmac.New(sha256.New, m.streamSecret)
+	mac.Write([]byte("stream\n" + trackID.String() + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// StreamHandler serves the playlists of stream links from StreamManifest at
+// /stream/{trackId}/{path...}. The signed link is the only credential, so
+// players need no session.
+func (m *MusicService) StreamHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		trackID, err := uuid.Parse(r.PathValue("trackId"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+		sig := m.streamSignature(trackID, q.Get("expires"))
+		if err != nil || !hmac.Equal([]byte(sig), []byte(q.Get("sig"))) || time.Now().Unix() > exp {
+			http.Error(w, "invalid or expired stream link", http.StatusForbidden)
+			return
+		}
+		expires := time.Unix(exp, 0)
+
+		var playlist string
+		if file := r.PathValue("path"); file == "master.m3u8" {
+			var variants []hlsVariant
+			if variants, err = m.readyVariants(r.Context(), trackID); err == nil && len(variants) == 0 {
+				err = ErrStreamNotReady
+			}
+			playlist = masterPlaylist(variants, "?"+m.streamQuery(trackID, expires).Encode())
+		} else if preset, ok := strings.CutSuffix(file, "/index.m3u8"); ok {
+			playlist, err = m.mediaPlaylist(r.Context(), trackID, preset, expires)
+		} else {
+			err = ErrStreamNotReady
+		}
+
+		if errors.Is(err, ErrStreamNotReady) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.Error("serve stream playlist", "track_id", trackID, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Segment URLs in it expire; players must not reuse a cached copy.
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		io.WriteString(w, playlist)
+	})
+}
+
+var mapURI = regexp.MustCompile(`URI="([^"]*)"`)
+
+// mediaPlaylist loads a stored media playlist and rewrites its init section
+// and segment URIs into signed URLs valid until expires.
+func (m *MusicService) mediaPlaylist(ctx context.Context, trackID uuid.UUID, preset string, expires time.Time) (string, error) {
+	var key string
+	query := `SELECT key FROM track_renditions WHERE track_id = $1 AND preset = $2 AND hls AND status = 'ready'`
+	err := m.db.QueryRowContext(ctx, query, trackID, preset).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", ErrStreamNotReady
+	}
+	if err != nil {
+		return "", err
+	}
+
+	body, err := m.Storage.Get(ctx, key, 0, -1)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	sign, err := m.segmentSigner(ctx, path.Dir(key)+"/", expires)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		b       strings.Builder
+		scanner = bufio.NewScanner(body)
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			var signErr error
+			line = mapURI.ReplaceAllStringFunc(line, func(attr string) string {
+				signed, err := sign(mapURI.FindStringSubmatch(attr)[1])
+				if err != nil {
+					signErr = err
+				}
+				return `URI="` + signed + `"`
+			})
+			if signErr != nil {
+				return "", signErr
+			}
+		case line != "" && !strings.HasPrefix(line, "#"):
+			if line, err = sign(line); err != nil {
+				return "", err
+			}
+		}
+
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// segmentSigner returns a function that turns a file name in the directory
+// dir into a signed URL. Through the CDN one wildcard signature covers the
+// whole directory.
+func (m *MusicService) segmentSigner(ctx context.Context, dir string, expires time.Time) (func(name string) (string, error), error) {
+	if m.CDNSigner != nil {
+		base := m.CDN + (&url.URL{Path: "/" + dir}).EscapedPath()
+		q, err := m.CDNSigner.SignedQuery(base+"*", expires)
+		if err != nil {
+			return nil, err
+		}
+		query := q.Encode()
+
+		return func(name string) (string, error) {
+			return base + (&url.URL{Path: name}).EscapedPath() + "?" + query, nil
+		}, nil
+	}
+
+	return func(name string) (string, error) {
+		return m.Storage.PresignGet(ctx, dir+name, expires)
+	}, nil
+}
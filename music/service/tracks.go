@@ -0,0 +1,344 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"music-auth/graph/model"
+	"music-auth/internal/common"
+	"music-auth/internal/middleware"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const trackColumns = `
+        t.id, t.album_id, t.album_position, t.title, t.artist, t.genre, t.duration, t.file_size,
+        t.format, t.sample_rate, t.channels, t.bitrate, t.tags, t.created_at, t.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTrack(row rowScanner) (*model.Track, error) {
+	t, _, err := scanTrackCreated(row)
+	return t, err
+}
+
+// scanTrackCreated also returns the exact creation time, which the
+// formatted CreatedAt rounds to the second, for pagination cursors.
+func scanTrackCreated(row rowScanner) (*model.Track, time.Time, error) {
+	var (
+		t                    model.Track
+		albumID              uuid.NullUUID
+		position, duration   sql.NullInt32
+		fileSize             sql.NullInt64
+		artist, genre        sql.NullString
+		sampleRate, bitrate  sql.NullInt32
+		channels             sql.NullInt32
+		tags                 []byte
+		createdAt, updatedAt time.Time
+	)
+
+	err := row.Scan(&t.ID, &albumID, &position, &t.Title, &artist, &genre, &duration, &fileSize,
+		&t.Format, &sampleRate, &channels, &bitrate, &tags, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if t.Tags, err = trackTags(tags); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if albumID.Valid {
+		t.AlbumID = &albumID.UUID
+	}
+	if position.Valid {
+		t.Position = &position.Int32
+	}
+	if artist.Valid {
+		t.Artist = &artist.String
+	}
+	if genre.Valid {
+		t.Genre = &genre.String
+	}
+	if duration.Valid {
+		t.Duration = &duration.Int32
+	}
+	if fileSize.Valid {
+		t.FileSize = &fileSize.Int64
+	}
+	if sampleRate.Valid {
+		t.SampleRate = &sampleRate.Int32
+	}
+	if channels.Valid {
+		t.Channels = &channels.Int32
+	}
+	if bitrate.Valid {
+		t.Bitrate = &bitrate.Int32
+	}
+	t.CreatedAt = createdAt.Format(time.RFC3339)
+	t.UpdatedAt = updatedAt.Format(time.RFC3339)
+
+	return &t, createdAt, nil
+}
+
+// trackTags turns the stored tag object into a list sorted by name.
+func trackTags(raw []byte) ([]*model.TrackTag, error) {
+	var tags map[string]string
+	if err := json.Unmarshal(raw, &tags); err != nil {
+		return nil, err
+	}
+
+	list := make([]*model.TrackTag, 0, len(tags))
+	for name, value := range tags {
+		list = append(list, &model.TrackTag{Name: name, Value: value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+const maxTracksPageSize = 100
+
+var ErrTrackNotFound = errors.New("track not found")
+
+// trackAccess is albumAccess for tracks t.
+const trackAccess = `t.tenant_id = $2 AND (t.user_id = $3 OR $4)`
+
+func (m *MusicService) getTrack(ctx context.Context, q queryer, id uuid.UUID) (*model.Track, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	query := `SELECT ` + trackColumns + ` FROM tracks t WHERE t.id = $1 AND ` + trackAccess
+
+	track, err := scanTrack(q.QueryRowContext(ctx, query, append([]any{id}, accessArgs(claims)...)...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTrackNotFound
+		}
+		return nil, fmt.Errorf("failed to load track: %w", err)
+	}
+
+	return track, nil
+}
+
+// Track returns one of the caller's tracks, or any track of the tenant for
+// catalog managers.
+func (m *MusicService) Track(ctx context.Context, id uuid.UUID) (*model.Track, error) {
+	return m.getTrack(ctx, m.db, id)
+}
+
+// MyTracks lists the caller's tracks, newest first, with keyset pagination
+// on (created_at, id).
+func (m *MusicService) MyTracks(ctx context.Context, filter *model.TrackFilter, first int, after *string) (*model.TrackConnection, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	if first <= 0 || first > maxTracksPageSize {
+		first = maxTracksPageSize
+	}
+
+	where := []string{"t.tenant_id = $1", "t.user_id = $2"}
+	args := []any{claims.Tenant, claims.UserID}
+
+	if filter != nil {
+		if filter.Query != nil && *filter.Query != "" {
+			args = append(args, "%"+common.EscapeLike(*filter.Query)+"%")
+			where = append(where, fmt.Sprintf("(t.title ILIKE $%d OR t.artist ILIKE $%d)", len(args), len(args)))
+		}
+		if filter.AlbumID != nil {
+			args = append(args, *filter.AlbumID)
+			where = append(where, fmt.Sprintf("t.album_id = $%d", len(args)))
+		}
+		if filter.Genre != nil && *filter.Genre != "" {
+			args = append(args, *filter.Genre)
+			where = append(where, fmt.Sprintf("lower(t.genre) = lower($%d)", len(args)))
+		}
+	}
+
+	var total int32
+	countQuery := `SELECT count(*) FROM tracks t WHERE ` + strings.Join(where, " AND ")
+	if err := m.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to load tracks: %w", err)
+	}
+
+	if after != nil && *after != "" {
+		createdAt, id, err := common.DecodeCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		where = append(where, fmt.Sprintf("(t.created_at, t.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	args = append(args, first+1)
+	query := `
+        SELECT ` + trackColumns + `
+        FROM tracks t
+        WHERE ` + strings.Join(where, " AND ") + fmt.Sprintf(`
+        ORDER BY t.created_at DESC, t.id DESC
+        LIMIT $%d`, len(args))
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tracks: %w", err)
+	}
+	defer rows.Close()
+
+	conn := &model.TrackConnection{Nodes: []*model.Track{}, TotalCount: total, PageInfo: &model.PageInfo{}}
+	var lastCreated time.Time
+
+	for rows.Next() {
+		if len(conn.Nodes) == first {
+			conn.PageInfo.HasNextPage = true
+			break
+		}
+
+		track, createdAt, err := scanTrackCreated(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tracks: %w", err)
+		}
+		lastCreated = createdAt
+
+		conn.Nodes = append(conn.Nodes, track)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load tracks: %w", err)
+	}
+
+	if n := len(conn.Nodes); n > 0 {
+		cursor := common.EncodeCursor(lastCreated, conn.Nodes[n-1].ID)
+		conn.PageInfo.EndCursor = &cursor
+	}
+
+	return conn, nil
+}
+
+func (m *MusicService) UpdateTrack(ctx context.Context, id uuid.UUID, input model.UpdateTrackInput) (*model.Track, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update track: %w", err)
+	}
+	defer tx.Rollback()
+
+	track, err := m.updateTrack(ctx, tx, id, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update track: %w", err)
+	}
+
+	return track, nil
+}
+
+func (m *MusicService) updateTrack(ctx context.Context, tx *sql.Tx, id uuid.UUID, input model.UpdateTrackInput) (*model.Track, error) {
+	claims := middleware.CurrentUser(ctx)
+
+	removeFromAlbum := input.RemoveFromAlbum != nil && *input.RemoveFromAlbum
+	if removeFromAlbum && input.AlbumID != nil {
+		return nil, fmt.Errorf("albumId and removeFromAlbum cannot be combined")
+	}
+
+	var (
+		ownerID uuid.UUID
+		albumID uuid.NullUUID
+	)
+	query := `SELECT t.user_id, t.album_id FROM tracks t WHERE t.id = $1 AND ` + trackAccess + ` FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, append([]any{id}, accessArgs(claims)...)...).Scan(&ownerID, &albumID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTrackNotFound
+		}
+		return nil, fmt.Errorf("failed to update track: %w", err)
+	}
+
+	args := []any{id}
+	set := []string{"updated_at = now()"}
+
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if title == "" {
+			return nil, fmt.Errorf("title cannot be empty")
+		}
+		args = append(args, title)
+		set = append(set, fmt.Sprintf("title = $%d", len(args)))
+	}
+	if input.Artist != nil {
+		args = append(args, nullIfEmpty(*input.Artist))
+		set = append(set, fmt.Sprintf("artist = $%d", len(args)))
+	}
+	if input.Genre != nil {
+		args = append(args, nullIfEmpty(*input.Genre))
+		set = append(set, fmt.Sprintf("genre = $%d", len(args)))
+	}
+
+	if removeFromAlbum {
+		set = append(set, "album_id = NULL", "album_position = NULL")
+	}
+
+	if input.AlbumID != nil && (!albumID.Valid || albumID.UUID != *input.AlbumID) {
+		// Tracks only join albums of their own owner, even when a catalog
+		// manager moves them. The lock serializes appends to the album.
+		var found uuid.UUID
+		query := `SELECT id FROM albums WHERE id = $1 AND tenant_id = $2 AND user_id = $3 FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, *input.AlbumID, claims.Tenant, ownerID).Scan(&found)
+		if err == sql.ErrNoRows {
+			return nil, ErrAlbumNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update track: %w", err)
+		}
+
+		args = append(args, *input.AlbumID)
+		set = append(set,
+			fmt.Sprintf("album_id = $%d", len(args)),
+			fmt.Sprintf("album_position = (SELECT COALESCE(max(album_position), 0) + 1 FROM tracks WHERE album_id = $%d)", len(args)),
+		)
+	}
+
+	query = `UPDATE tracks SET ` + strings.Join(set, ", ") + ` WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to update track: %w", err)
+	}
+
+	return m.getTrack(ctx, tx, id)
+}
+
+// DeleteTrack removes the track and queues the deletion of its files,
+// renditions included, in the same transaction, so files are only removed
+// with the track and are retried until they are gone.
+func (m *MusicService) DeleteTrack(ctx context.Context, id uuid.UUID) error {
+	claims := middleware.CurrentUser(ctx)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete track: %w", err)
+	}
+	defer tx.Rollback()
+
+	var key string
+	query := `DELETE FROM tracks t WHERE t.id = $1 AND ` + trackAccess + ` RETURNING t.key`
+
+	err = tx.QueryRowContext(ctx, query, append([]any{id}, accessArgs(claims)...)...).Scan(&key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTrackNotFound
+		}
+		return fmt.Errorf("failed to delete track: %w", err)
+	}
+
+	if err := m.QueueFileDeletion(ctx, tx, []string{key}); err != nil {
+		return fmt.Errorf("failed to delete track: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete track: %w", err)
+	}
+
+	return nil
+}
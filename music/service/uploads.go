@@ -0,0 +1,94 @@
+package music
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"music-auth/music/storage"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	uploadURLTTL = 15 * time.Minute
+	// pendingUploadTTL is how long after the upload URL was issued the
+	// upload can still be saved.
+	pendingUploadTTL = 24 * time.Hour
+	maxUploadSize    = 500 << 20
+)
+
+var ErrUnknownUpload = errors.New("unknown upload key, request a new upload URL")
+
+// Upload is a presigned PUT for a new object.
+type Upload struct {
+	URL       string
+	Key       string
+	ExpiresAt time.Time
+}
+
+// allowedUploadType accepts audio for tracks and images for cover art.
+func allowedUploadType(contentType string) bool {
+	return strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "image/")
+}
+
+// claimUpload checks that key was issued to the user with a content type
+// starting with kind, and that the stored object is what was announced. It
+// removes the pending upload in tx, so a key is saved at most once, and
+// returns the object's real size.
+func (m *MusicService) claimUpload(ctx context.Context, tx *sql.Tx, tenant string, userID uuid.UUID, key, kind string) (int64, error) {
+	query := `
+        DELETE FROM pending_uploads
+        WHERE key = $1 AND tenant_id = $2 AND user_id = $3 AND expires_at > now()
+        RETURNING content_type, file_size, upload_id IS NOT NULL, completed_at IS NOT NULL
+    `
+
+	var (
+		contentType         string
+		declaredSize        sql.NullInt64
+		multipart, complete bool
+	)
+	err := tx.QueryRowContext(ctx, query, key, tenant, userID).Scan(&contentType, &declaredSize, &multipart, &complete)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrUnknownUpload
+		}
+		return 0, fmt.Errorf("failed to check upload: %w", err)
+	}
+
+	if !strings.HasPrefix(contentType, kind) {
+		return 0, fmt.Errorf("the uploaded file is not an %s file", strings.TrimSuffix(kind, "/"))
+	}
+
+	maxSize := int64(maxUploadSize)
+	if multipart {
+		if !complete {
+			return 0, fmt.Errorf("the multipart upload has not been completed")
+		}
+		maxSize = maxMultipartUploadSize
+	}
+
+	head, err := m.Storage.Head(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, fmt.Errorf("the file has not been uploaded yet")
+		}
+		return 0, fmt.Errorf("failed to check upload: %w", err)
+	}
+
+	size := head.Size
+
+	if head.ContentType != contentType {
+		return 0, fmt.Errorf("the uploaded file does not have the announced content type")
+	}
+	if declaredSize.Valid && size != declaredSize.Int64 {
+		return 0, fmt.Errorf("the uploaded file does not have the announced size")
+	}
+	if size <= 0 || size > maxSize {
+		return 0, fmt.Errorf("file size must be between 1 byte and %d MB", maxSize>>20)
+	}
+
+	return size, nil
+}
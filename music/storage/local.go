@@ -0,0 +1,531 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalPath is where the server must mount Local.Handler.
+const LocalPath = "/storage/"
+
+// maxLocalPut matches S3's limit for a single PUT or part.
+const maxLocalPut = 5 << 30
+
+// Local keeps objects on disk, for development and single machines. The
+// server itself serves its presigned URLs, which carry an HMAC instead of
+// an AWS signature.
+//
+// Keys nest (renditions live under the key of their upload), which a file
+// tree cannot mirror, so objects are stored under the hash of their key
+// with a small JSON file holding the key and content type.
+type Local struct {
+	Dir     string
+	baseURL string
+	secret  []byte
+}
+
+type localMeta struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Initiated   time.Time `json:"initiated,omitzero"`
+}
+
+func NewLocal(dir, publicURL, secret string) (*Local, error) {
+	for _, sub := range []string{"objects", "multipart", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("create storage dir: %w", err)
+		}
+	}
+
+	return &Local{
+		Dir:     dir,
+		baseURL: strings.TrimRight(publicURL, "/") + strings.TrimSuffix(LocalPath, "/"),
+		secret:  []byte(secret),
+	}, nil
+}
+
+func (l *Local) Bucket() string {
+	return "local"
+}
+
+func (l *Local) objectPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	h := hex.EncodeToString(sum[:])
+	return filepath.Join(l.Dir, "objects", h[:2], h)
+}
+
+func (l *Local) uploadDir(uploadID string) (string, error) {
+	// Upload IDs come from URLs; only accept the ones we make.
+	if _, err := hex.DecodeString(uploadID); err != nil || len(uploadID) != 32 {
+		return "", ErrNotFound
+	}
+	return filepath.Join(l.Dir, "multipart", uploadID), nil
+}
+
+func (l *Local) PresignPut(ctx context.Context, key, contentType string, size *int64, expires time.Time) (string, error) {
+	q := url.Values{"X-Content-Type": {contentType}}
+	if size != nil {
+		q.Set("X-Size", strconv.FormatInt(*size, 10))
+	}
+	return l.signURL(http.MethodPut, key, q, expires), nil
+}
+
+func (l *Local) PresignGet(ctx context.Context, key string, expires time.Time) (string, error) {
+	return l.signURL(http.MethodGet, key, url.Values{}, expires), nil
+}
+
+func (l *Local) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Time) (string, error) {
+	q := url.Values{"uploadId": {uploadID}, "partNumber": {strconv.Itoa(int(partNumber))}}
+	return l.signURL(http.MethodPut, key, q, expires), nil
+}
+
+func (l *Local) signURL(method, key string, q url.Values, expires time.Time) string {
+	q.Set("X-Expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("X-Signature", l.signature(method, key, q))
+	return l.baseURL + (&url.URL{Path: "/" + key}).EscapedPath() + "?" + q.Encode()
+}
+
+func (l *Local) signature(method, key string, q url.Values) string {
+	mac := hmac.New(sha256.New, l.secret)
+	io.WriteString(mac, strings.Join([]string{
+		"storage", method, key, q.Get("X-Expires"), q.Get("X-Content-Type"), q.Get("X-Size"), q.Get("uploadId"), q.Get("partNumber"),
+	}, "\n"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) verify(method, key string, q url.Values) bool {
+	expires, err := strconv.ParseInt(q.Get("X-Expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("X-Signature")), []byte(l.signature(method, key, q)))
+}
+
+func (l *Local) Head(ctx context.Context, key string) (*Object, error) {
+	meta, err := readMeta(l.objectPath(key) + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(l.objectPath(key))
+	if err != nil {
+		return nil, notExist(err)
+	}
+
+	return &Object{Key: key, Size: stat.Size(), ContentType: meta.ContentType}, nil
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+func (l *Local) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if _, err := readMeta(l.objectPath(key) + ".json"); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(l.objectPath(key))
+	if err != nil {
+		return nil, notExist(err)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return limitedFile{io.LimitReader(f, length), f}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	_, err := l.write(key, contentType, body)
+	return err
+}
+
+// write stores an object through a temporary file, so readers never see it
+// half-written, and returns its size. The metadata goes last, as objects
+// without it do not exist.
+func (l *Local) write(key, contentType string, body io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(l.Dir, "tmp"), "put-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, body)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	file := l.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return 0, err
+	}
+
+	return n, writeMeta(l.Dir, file+".json", localMeta{Key: key, ContentType: contentType})
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.WalkDir(filepath.Join(l.Dir, "objects"), func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(file, ".json") {
+			return err
+		}
+		meta, err := readMeta(file)
+		if err == ErrNotFound {
+			// Deleted meanwhile.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(meta.Key, prefix) {
+			keys = append(keys, meta.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (l *Local) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		file := l.objectPath(key)
+		for _, f := range []string{file + ".json", file} {
+			if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *Local) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	uploadID := hex.EncodeToString(id)
+
+	dir, _ := l.uploadDir(uploadID)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	meta := localMeta{Key: key, ContentType: contentType, Initiated: time.Now()}
+	if err := writeMeta(l.Dir, filepath.Join(dir, "upload.json"), meta); err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// upload returns the metadata of a multipart upload of key.
+func (l *Local) upload(key, uploadID string) (string, *localMeta, error) {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	meta, err := readMeta(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return "", nil, err
+	}
+	if meta.Key != key {
+		return "", nil, ErrNotFound
+	}
+
+	return dir, meta, nil
+}
+
+func (l *Local) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	dir, _, err := l.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, notExist(err)
+	}
+
+	var parts []Part
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{Number: int32(n), Size: info.Size()})
+	}
+
+	slices.SortFunc(parts, func(a, b Part) int { return int(a.Number - b.Number) })
+	return parts, nil
+}
+
+func (l *Local) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir, meta, err := l.upload(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	files := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(int(p.Number))))
+		if err != nil {
+			return fmt.Errorf("part %d: %w", p.Number, notExist(err))
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	if _, err := l.write(key, meta.ContentType, io.MultiReader(files...)); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (l *Local) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, _, err := l.upload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *Local) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(l.Dir, "multipart"))
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []MultipartUpload
+	for _, e := range entries {
+		meta, err := readMeta(filepath.Join(l.Dir, "multipart", e.Name(), "upload.json"))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(meta.Key, prefix) {
+			uploads = append(uploads, MultipartUpload{Key: meta.Key, UploadID: e.Name(), Initiated: meta.Initiated})
+		}
+	}
+
+	return uploads, nil
+}
+
+// Handler serves the presigned URLs: GET and HEAD of objects, PUT of
+// objects and parts. Browsers may call it from any origin, as the URLs
+// themselves are the credential.
+func (l *Local) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		key := strings.TrimPrefix(r.URL.Path, LocalPath)
+		q := r.URL.Query()
+
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Range")
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodGet, http.MethodHead:
+			if !l.verify(http.MethodGet, key, q) {
+				http.Error(w, "invalid or expired signature", http.StatusForbidden)
+				return
+			}
+			l.serveObject(w, r, key)
+
+		case http.MethodPut:
+			if !l.verify(http.MethodPut, key, q) {
+				http.Error(w, "invalid or expired signature", http.StatusForbidden)
+				return
+			}
+			if q.Has("uploadId") {
+				l.putPart(w, r, key, q)
+			} else {
+				l.putObject(w, r, key, q)
+			}
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, OPTIONS")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (l *Local) serveObject(w http.ResponseWriter, r *http.Request, key string) {
+	meta, err := readMeta(l.objectPath(key) + ".json")
+
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(l.objectPath(key))
+		err = notExist(err)
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", meta.ContentType)
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
+
+// putObject checks the request against what was signed, as S3 does with
+// the signed headers.
+func (l *Local) putObject(w http.ResponseWriter, r *http.Request, key string, q url.Values) {
+	contentType := q.Get("X-Content-Type")
+	if r.Header.Get("Content-Type") != contentType {
+		http.Error(w, "Content-Type does not match the signature", http.StatusForbidden)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxLocalPut)
+
+	if s := q.Get("X-Size"); s != "" {
+		size, _ := strconv.ParseInt(s, 10, 64)
+		if r.ContentLength != size {
+			http.Error(w, "Content-Length does not match the signature", http.StatusForbidden)
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, size)
+	}
+
+	if _, err := l.write(key, contentType, body); err != nil {
+		http.Error(w, "failed to store object", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (l *Local) putPart(w http.ResponseWriter, r *http.Request, key string, q url.Values) {
+	n, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || n < 1 {
+		http.Error(w, "invalid part number", http.StatusBadRequest)
+		return
+	}
+
+	dir, _, err := l.upload(key, q.Get("uploadId"))
+	if err != nil {
+		http.Error(w, "no such upload", http.StatusNotFound)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(l.Dir, "tmp"), "part-")
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxLocalPut))
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(n)))
+	}
+	if err != nil {
+		http.Error(w, "failed to store part", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func readMeta(file string) (*localMeta, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, notExist(err)
+	}
+
+	var meta localMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("read %s: %w", file, err)
+	}
+	return &meta, nil
+}
+
+func writeMeta(root, file string, meta localMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(root, "tmp"), "meta-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+func notExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 stores objects in an S3 bucket, or a bucket of an S3-compatible server
+// such as MinIO.
+type S3 struct {
+	Client    *s3.Client
+	Uploader  *manager.Uploader
+	Presigner *s3.PresignClient
+	bucket    string
+}
+
+func NewS3(client *s3.Client, bucket string) *S3 {
+	return &S3{
+		Client:    client,
+		Uploader:  manager.NewUploader(client),
+		Presigner: s3.NewPresignClient(client),
+		bucket:    bucket,
+	}
+}
+
+func newS3FromEnv(minio bool) (*S3, error) {
+	bucket := os.Getenv("AWS_BUCKET_NAME")
+	if bucket == "" {
+		return nil, fmt.Errorf("AWS_BUCKET_NAME is required")
+	}
+
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if minio && endpoint == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT is required")
+	}
+
+	// MinIO serves buckets under the path unless set up for virtual hosts.
+	pathStyle := minio
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		pathStyle = v == "true"
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		if !minio {
+			return nil, fmt.Errorf("AWS_REGION is required")
+		}
+		// MinIO's default region.
+		region = "us-east-1"
+	}
+
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+
+	// Without static keys the default chain applies, e.g. an instance role.
+	accessKey := os.Getenv("AWS_S3_BUCKET_ACCESS_KEY")
+	secretKey := os.Getenv("AWS_S3_BUCKET_SECRET_ACCESS_KEY")
+	if accessKey != "" || secretKey != "" {
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("AWS_S3_BUCKET_ACCESS_KEY and AWS_S3_BUCKET_SECRET_ACCESS_KEY go together")
+		}
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = pathStyle
+	})
+
+	return NewS3(client, bucket), nil
+}
+
+func (s *S3) Bucket() string {
+	return s.bucket
+}
+
+func (s *S3) PresignPut(ctx context.Context, key, contentType string, size *int64, expires time.Time) (string, error) {
+	req, err := s.Presigner.PresignPutObject(ctx,
+		&s3.PutObjectInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			ContentType:   aws.String(contentType),
+			ContentLength: size,
+		},
+		s3.WithPresignExpires(time.Until(expires)),
+	)
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3) PresignGet(ctx context.Context, key string, expires time.Time) (string, error) {
+	req, err := s.Presigner.PresignGetObject(ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		},
+		s3.WithPresignExpires(time.Until(expires)),
+	)
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3) Head(ctx context.Context, key string) (*Object, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &Object{
+		Key:         key,
+		Size:        aws.ToInt64(out.ContentLength),
+		ContentType: aws.ToString(out.ContentType),
+	}, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	switch {
+	case length >= 0:
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	out, err := s.Client.GetObject(ctx, in)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	_, err := s.Uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	pages := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+
+	return keys, nil
+}
+
+// Delete sends the keys in batches, as S3 accepts at most 1000 keys per
+// request.
+func (s *S3) Delete(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := min(len(keys), 1000)
+
+		objects := make([]types.ObjectIdentifier, n)
+		for i, key := range keys[:n] {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		out, err := s.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete %d object(s), first: %s", len(out.Errors), aws.ToString(out.Errors[0].Key))
+		}
+
+		keys = keys[n:]
+	}
+
+	return nil
+}
+
+func (s *S3) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	out, err := s.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *S3) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Time) (string, error) {
+	req, err := s.Presigner.PresignUploadPart(ctx,
+		&s3.UploadPartInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key),
+			UploadId:   aws.String(uploadID),
+			PartNumber: aws.Int32(partNumber),
+		},
+		s3.WithPresignExpires(time.Until(expires)),
+	)
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+
+	pages := s3.NewListPartsPaginator(s.Client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, noSuchUpload(err)
+		}
+		for _, p := range page.Parts {
+			parts = append(parts, Part{
+				Number: aws.ToInt32(p.PartNumber),
+				Size:   aws.ToInt64(p.Size),
+				ETag:   aws.ToString(p.ETag),
+			})
+		}
+	}
+
+	return parts, nil
+}
+
+func (s *S3) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = types.CompletedPart{ETag: aws.String(p.ETag), PartNumber: aws.Int32(p.Number)}
+	}
+
+	_, err := s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return noSuchUpload(err)
+}
+
+func (s *S3) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := s.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return noSuchUpload(err)
+}
+
+func (s *S3) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+
+	pages := s3.NewListMultipartUploadsPaginator(s.Client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Key:       aws.ToString(u.Key),
+				UploadID:  aws.ToString(u.UploadId),
+				Initiated: aws.ToTime(u.Initiated),
+			})
+		}
+	}
+
+	return uploads, nil
+}
+
+func noSuchUpload(err error) error {
+	var notFound *types.NoSuchUpload
+	if errors.As(err, &notFound) {
+		return ErrNotFound
+	}
+	return err
+}
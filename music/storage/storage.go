@@ -0,0 +1,107 @@
+// Package storage keeps uploaded files and everything derived from them.
+// Objects are addressed by key, as in S3, and clients read and write them
+// directly through presigned URLs.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned for missing objects and unknown multipart uploads.
+var ErrNotFound = errors.New("not found")
+
+// Object describes a stored object.
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+}
+
+// Part is an uploaded part of a multipart upload.
+type Part struct {
+	Number int32
+	Size   int64
+	ETag   string
+}
+
+// MultipartUpload is a multipart upload that was neither completed nor
+// aborted.
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+type Storage interface {
+	// Bucket is the bucket name that event notifications carry.
+	Bucket() string
+
+	// PresignPut returns a URL to upload key with the content type, and the
+	// size if not nil, that are signed into it.
+	PresignPut(ctx context.Context, key, contentType string, size *int64, expires time.Time) (string, error)
+	PresignGet(ctx context.Context, key string, expires time.Time) (string, error)
+
+	Head(ctx context.Context, key string) (*Object, error)
+	// Get reads length bytes from offset, or the rest of the object if
+	// length is negative.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// List returns the keys starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes objects. Missing keys are not an error.
+	Delete(ctx context.Context, keys []string) error
+
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Time) (string, error)
+	// ListParts returns the uploaded parts in order.
+	ListParts(ctx context.Context, key, uploadID string) ([]Part, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+}
+
+// InitStorage picks a backend from STORAGE_DRIVER:
+//
+//	s3    – AWS_BUCKET_NAME, AWS_REGION, AWS_S3_BUCKET_ACCESS_KEY and
+//	        AWS_S3_BUCKET_SECRET_ACCESS_KEY (or the default AWS credentials),
+//	        optionally S3_ENDPOINT and S3_PATH_STYLE
+//	minio – the same, with S3_ENDPOINT required and path-style addressing
+//	local – files under STORAGE_DIR (default "storage"), served by the
+//	        server itself at publicURL
+//
+// Without STORAGE_DRIVER it uses s3 when AWS_BUCKET_NAME is set and local
+// otherwise. secret signs the URLs of the local backend.
+func InitStorage(publicURL, secret string) (Storage, error) {
+	driver := os.Getenv("STORAGE_DRIVER")
+	if driver == "" {
+		if os.Getenv("AWS_BUCKET_NAME") != "" {
+			driver = "s3"
+		} else {
+			driver = "local"
+		}
+	}
+
+	switch driver {
+	case "s3":
+		return newS3FromEnv(false)
+
+	case "minio":
+		return newS3FromEnv(true)
+
+	case "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "storage"
+		}
+		slog.Warn("files are stored on local disk", "dir", dir)
+		return NewLocal(dir, publicURL, secret)
+	}
+
+	return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+}
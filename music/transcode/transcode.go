@@ -0,0 +1,161 @@
+// Package transcode converts uploaded audio into the renditions served to
+// listeners.
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	CodecOpus = "opus"
+	CodecAAC  = "aac"
+)
+
+// HLSSegmentDuration is the target length of HLS segments, in seconds.
+const HLSSegmentDuration = 6
+
+// Preset describes one rendition. Name is stable and ends up in S3 keys and
+// the API, so presets are added, never renamed. HLS presets are packaged as
+// a playlist with segments instead of a single file.
+type Preset struct {
+	Name        string
+	Codec       string
+	Bitrate     int // kbit/s
+	Ext         string
+	ContentType string
+	HLS         bool
+}
+
+// Presets are produced for every track.
+var Presets = []Preset{
+	{Name: "opus_64", Codec: CodecOpus, Bitrate: 64, Ext: ".ogg", ContentType: "audio/ogg"},
+	{Name: "opus_96", Codec: CodecOpus, Bitrate: 96, Ext: ".ogg", ContentType: "audio/ogg"},
+	{Name: "opus_160", Codec: CodecOpus, Bitrate: 160, Ext: ".ogg", ContentType: "audio/ogg"},
+	{Name: "aac_128", Codec: CodecAAC, Bitrate: 128, Ext: ".m4a", ContentType: "audio/mp4"},
+	{Name: "aac_256", Codec: CodecAAC, Bitrate: 256, Ext: ".m4a", ContentType: "audio/mp4"},
+}
+
+// HLSVariants make up the adaptive stream of every track: AAC in fragmented
+// MP4, which all HLS players support.
+var HLSVariants = []Preset{
+	{Name: "hls_aac_64", Codec: CodecAAC, Bitrate: 64, HLS: true},
+	{Name: "hls_aac_128", Codec: CodecAAC, Bitrate: 128, HLS: true},
+	{Name: "hls_aac_256", Codec: CodecAAC, Bitrate: 256, HLS: true},
+}
+
+func PresetByName(name string) (Preset, bool) {
+	for _, p := range append(Presets, HLSVariants...) {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
+
+// Transcoder encodes the audio file at src into dst as described by p.
+// PackageHLS instead writes an HLS media playlist, index.m3u8, with its
+// init.mp4 and segments into the directory dir.
+type Transcoder interface {
+	Transcode(ctx context.Context, src, dst string, p Preset) error
+	PackageHLS(ctx context.Context, src, dir string, p Preset) error
+}
+
+// FFmpeg shells out to an ffmpeg binary built with libopus.
+type FFmpeg struct {
+	Path string
+}
+
+// InitTranscoder finds ffmpeg at FFMPEG_PATH or on the PATH. Without one it
+// returns nil, and tracks are only served as uploaded.
+func InitTranscoder() (Transcoder, error) {
+	path := os.Getenv("FFMPEG_PATH")
+	if path != "" {
+		if _, err := exec.LookPath(path); err != nil {
+			return nil, fmt.Errorf("FFMPEG_PATH: %w", err)
+		}
+		return &FFmpeg{Path: path}, nil
+	}
+
+	path, err := exec.LookPath("ffmpeg")
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &FFmpeg{Path: path}, nil
+}
+
+func (f *FFmpeg) Transcode(ctx context.Context, src, dst string, p Preset) error {
+	args, err := encodeArgs(src, p)
+	if err != nil {
+		return err
+	}
+	if p.Codec == CodecAAC {
+		args = append(args, "-movflags", "+faststart")
+	}
+
+	return f.run(ctx, append(args, dst))
+}
+
+func (f *FFmpeg) PackageHLS(ctx context.Context, src, dir string, p Preset) error {
+	args, err := encodeArgs(src, p)
+	if err != nil {
+		return err
+	}
+
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(HLSSegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%05d.m4s"),
+		filepath.Join(dir, "index.m3u8"),
+	)
+
+	return f.run(ctx, args)
+}
+
+// encodeArgs reads the first audio stream of src and encodes it for p. Cover
+// art and tags are dropped; the track row has them.
+func encodeArgs(src string, p Preset) ([]string, error) {
+	args := []string{
+		"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-i", src,
+		"-map", "0:a:0", "-map_metadata", "-1", "-vn",
+		"-b:a", fmt.Sprintf("%dk", p.Bitrate),
+	}
+
+	switch p.Codec {
+	case CodecOpus:
+		args = append(args, "-c:a", "libopus")
+	case CodecAAC:
+		args = append(args, "-c:a", "aac")
+	default:
+		return nil, fmt.Errorf("unknown codec %q", p.Codec)
+	}
+
+	return args, nil
+}
+
+func (f *FFmpeg) run(ctx context.Context, args []string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.Path, args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
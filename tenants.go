@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"music-auth/global/db"
+	"music-auth/internal/tenant"
+	"os"
+	"strings"
+)
+
+// defaultTenant is DEFAULT_TENANT, falling back to the tenant every existing
+// row was migrated into.
+func defaultTenant() string {
+	if id, ok := os.LookupEnv("DEFAULT_TENANT"); ok {
+		return id
+	}
+	return "music-store"
+}
+
+// runTenants implements `music-auth tenants create|list`.
+func runTenants(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: tenants create -id id -name n [-host h] | tenants list")
+	}
+
+	conn, err := db.Open()
+	if err != nil {
+		return fmt.Errorf("connect DB: %w", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		var hosts listFlag
+		flags := flag.NewFlagSet("tenants create", flag.ContinueOnError)
+		id := flags.String("id", "", "tenant id, carried in tokens")
+		name := flags.String("name", "", "display name")
+		flags.Var(&hosts, "host", "host name that resolves to this tenant (repeatable)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if err := tenant.Create(ctx, conn, tenant.Tenant{ID: *id, Name: *name, Hosts: hosts}); err != nil {
+			return err
+		}
+		fmt.Printf("created tenant %s\n", *id)
+
+	case "list":
+		tenants, err := tenant.List(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, t := range tenants {
+			fmt.Printf("%-16s  %-20s  %s\n", t.ID, t.Name, strings.Join(t.Hosts, " "))
+		}
+
+	default:
+		return fmt.Errorf("unknown tenants command %q", args[0])
+	}
+
+	return nil
+}